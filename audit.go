@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditConfig holds settings for the audit log, which records every request
+// sent to a provider
+type AuditConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// Path of the log, defaults to ~/.commitly/audit.jsonl
+	Path string `json:"path,omitempty"`
+	// StorePrompts also records the full system and user prompts
+	StorePrompts bool `json:"store_prompts,omitempty"`
+}
+
+func (c AuditConfig) logPath() string {
+	if c.Path != "" {
+		return c.Path
+	}
+	return filepath.Join(getDataDir(), "audit.jsonl")
+}
+
+// setAuditValue sets a key of the audit config section.
+func setAuditValue(audit *AuditConfig, key, value string) error {
+	switch key {
+	case "enabled", "store_prompts":
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("audit.%s must be true or false", key)
+		}
+		if key == "enabled" {
+			audit.Enabled = enabled
+		} else {
+			audit.StorePrompts = enabled
+		}
+	case "path":
+		audit.Path = value
+	default:
+		return fmt.Errorf("unknown key for audit: %s", key)
+	}
+	return nil
+}
+
+// getAuditValue returns a key of the audit config section.
+func getAuditValue(audit AuditConfig, key string) (string, bool) {
+	switch key {
+	case "enabled":
+		return strconv.FormatBool(audit.Enabled), true
+	case "store_prompts":
+		return strconv.FormatBool(audit.StorePrompts), true
+	case "path":
+		return audit.logPath(), true
+	}
+	return "", false
+}
+
+// auditRecord is one line of the audit log. It describes a request sent to a
+// provider, including the ones that failed.
+type auditRecord struct {
+	Time     time.Time `json:"time"`
+	Repo     string    `json:"repo"`
+	Provider string    `json:"provider"`
+	Model    string    `json:"model"`
+	// PromptHash is the SHA-256 of the system and user prompts and of the
+	// schema of the structured output
+	PromptHash    string         `json:"prompt_hash"`
+	PromptBytes   int            `json:"prompt_bytes"`
+	ResponseBytes int            `json:"response_bytes"`
+	Redactions    []redactionHit `json:"redactions,omitempty"`
+	// Withheld are the files left out of the diff by the privacy policy
+	Withheld []string `json:"withheld,omitempty"`
+	// Schema is the JSON schema the response was asked to follow
+	Schema   string `json:"schema,omitempty"`
+	Response string `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// System and Prompt are only recorded when store_prompts is enabled
+	System string `json:"system,omitempty"`
+	Prompt string `json:"prompt,omitempty"`
+}
+
+// unsentError is an error raised before a request left the machine, such as
+// a missing API key. Such requests are not audited.
+type unsentError struct{ error }
+
+// promptHash identifies the prompts that were sent without storing them.
+func promptHash(sent sentRequest) string {
+	sum := sha256.Sum256([]byte(sent.System + "\x00" + sent.Prompt + "\x00" + sent.Schema))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordAudit appends a request and its outcome to the audit log. sent is
+// what the provider was sent for req, and result is nil when the request
+// failed with reqErr.
+func recordAudit(cfg AuditConfig, req generationRequest, sent sentRequest, provider Provider, model string, result *generationResult, reqErr error) error {
+	record := auditRecord{
+		Time:        time.Now(),
+		Repo:        getRepoRoot(),
+		Provider:    string(provider),
+		Model:       model,
+		PromptHash:  promptHash(sent),
+		PromptBytes: len(sent.System) + len(sent.Prompt) + len(sent.Schema),
+		Redactions:  req.Redactions,
+		Withheld:    req.Withheld,
+		Schema:      sent.Schema,
+	}
+	if result != nil {
+		record.Model = result.Model
+		record.Response = result.Text
+		record.ResponseBytes = len(result.Text)
+	}
+	if reqErr != nil {
+		record.Error = reqErr.Error()
+	}
+	if cfg.StorePrompts {
+		record.System = sent.System
+		record.Prompt = sent.Prompt
+	}
+	return appendJSONLine(cfg.logPath(), record)
+}
+
+// loadAudit reads the audit log at path, skipping records older than since.
+func loadAudit(path string, since time.Time) ([]auditRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %v", err)
+	}
+	defer f.Close()
+
+	var records []auditRecord
+	scanner := bufio.NewScanner(f)
+	// Records with full prompts can be far larger than the default limit
+	scanner.Buffer(make([]byte, 0, 1<<20), 64<<20)
+	for scanner.Scan() {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if !record.Time.Before(since) {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading audit log: %v", err)
+	}
+	return records, nil
+}
+
+// auditFilter selects audit records from the command line flags.
+type auditFilter struct {
+	since    *string
+	repo     *string
+	provider *string
+	model    *string
+	query    *string
+}
+
+func newAuditFilter(fs *flag.FlagSet) *auditFilter {
+	return &auditFilter{
+		since:    fs.String("since", "30d", "only include records since a date (YYYY-MM-DD) or a number of days (30d)"),
+		repo:     fs.String("repo", "", "only include repositories whose path contains this text"),
+		provider: fs.String("provider", "", "only include this provider"),
+		model:    fs.String("model", "", "only include this model"),
+		query:    fs.String("query", "", "only include records whose prompt hash starts with, or whose response, prompts or error contain, this text"),
+	}
+}
+
+func (f *auditFilter) load(cfg AuditConfig) ([]auditRecord, error) {
+	start, err := parseSince(*f.since)
+	if err != nil {
+		return nil, err
+	}
+	records, err := loadAudit(cfg.logPath(), start)
+	if err != nil {
+		return nil, err
+	}
+
+	query := strings.ToLower(*f.query)
+	var selected []auditRecord
+	for _, record := range records {
+		if *f.repo != "" && !strings.Contains(record.Repo, *f.repo) {
+			continue
+		}
+		if *f.provider != "" && !strings.EqualFold(record.Provider, *f.provider) {
+			continue
+		}
+		if *f.model != "" && record.Model != *f.model {
+			continue
+		}
+		if query != "" && !strings.HasPrefix(record.PromptHash, query) &&
+			!strings.Contains(strings.ToLower(record.Response+"\x00"+record.Prompt+"\x00"+record.System+"\x00"+record.Error), query) {
+			continue
+		}
+		selected = append(selected, record)
+	}
+	return selected, nil
+}
+
+// runAudit implements "commitly audit".
+func runAudit(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: commitly audit <search|export> [flags]")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "search":
+		searchCmd := flag.NewFlagSet("audit search", flag.ExitOnError)
+		filter := newAuditFilter(searchCmd)
+		searchCmd.Parse(args[1:])
+
+		records, err := filter.load(cfg.Audit)
+		if err != nil {
+			return err
+		}
+		if !cfg.Audit.Enabled {
+			fmt.Fprintln(os.Stderr, "Note: the audit log is disabled, enable it with: commitly config set audit.enabled true")
+		}
+		for _, record := range records {
+			fmt.Printf("%s  %s/%s  %s\n", record.Time.Local().Format(time.RFC3339), record.Provider, record.Model, record.Repo)
+			hash := record.PromptHash
+			if len(hash) > 12 {
+				hash = hash[:12]
+			}
+			fmt.Printf("  prompt %s, %d bytes sent, %d bytes received\n", hash, record.PromptBytes, record.ResponseBytes)
+			if len(record.Redactions) > 0 {
+				fmt.Printf("  redacted: %s\n", summarizeHits(record.Redactions))
+			}
+			if len(record.Withheld) > 0 {
+				fmt.Printf("  withheld: %s\n", strings.Join(record.Withheld, ", "))
+			}
+			if record.Error != "" {
+				fmt.Printf("  error: %s\n", strings.SplitN(record.Error, "\n", 2)[0])
+			}
+		}
+		fmt.Printf("%d records\n", len(records))
+	case "export":
+		exportCmd := flag.NewFlagSet("audit export", flag.ExitOnError)
+		filter := newAuditFilter(exportCmd)
+		format := exportCmd.String("format", "jsonl", "export format: jsonl or csv")
+		output := exportCmd.String("output", "", "file to write, defaults to standard output")
+		exportCmd.Parse(args[1:])
+
+		if *format != "jsonl" && *format != "csv" {
+			return fmt.Errorf("invalid --format value %q, expected jsonl or csv", *format)
+		}
+		records, err := filter.load(cfg.Audit)
+		if err != nil {
+			return err
+		}
+
+		var w io.Writer = os.Stdout
+		if *output != "" {
+			f, err := os.OpenFile(*output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+			if err != nil {
+				return fmt.Errorf("error creating %s: %v", *output, err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if *format == "csv" {
+			err = exportAuditCSV(w, records)
+		} else {
+			err = exportAuditJSONL(w, records)
+		}
+		if err != nil {
+			return err
+		}
+		if *output != "" {
+			fmt.Fprintf(os.Stderr, "Exported %d records to %s\n", len(records), *output)
+		}
+	default:
+		return fmt.Errorf("unknown audit command %q, expected search or export", args[0])
+	}
+	return nil
+}
+
+func exportAuditJSONL(w io.Writer, records []auditRecord) error {
+	enc := json.NewEncoder(w)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return fmt.Errorf("error writing audit export: %v", err)
+		}
+	}
+	return nil
+}
+
+func exportAuditCSV(w io.Writer, records []auditRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "repo", "provider", "model", "prompt_hash", "prompt_bytes", "response_bytes",
+		"redactions", "withheld", "error", "response"})
+	for _, record := range records {
+		cw.Write([]string{
+			record.Time.Format(time.RFC3339),
+			record.Repo,
+			record.Provider,
+			record.Model,
+			record.PromptHash,
+			strconv.Itoa(record.PromptBytes),
+			strconv.Itoa(record.ResponseBytes),
+			summarizeHits(record.Redactions),
+			strings.Join(record.Withheld, "; "),
+			record.Error,
+			record.Response,
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("error writing audit export: %v", err)
+	}
+	return nil
+}
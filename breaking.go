@@ -0,0 +1,176 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// breakingChange is a change that likely breaks users of the code
+type breakingChange struct {
+	File   string
+	Reason string
+}
+
+var (
+	// flagDefinitionPatterns find the name of a command line flag defined
+	// with the Go flag package or cobra, Python argparse or JS commander
+	flagDefinitionPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\.(?:String|Bool|Int|Int64|Uint|Uint64|Float64|Duration|StringSlice|Func|TextVar|Var)(?:Var)?P?\(\s*(?:&[\w.\[\]]+\s*,\s*)?"([\w-]+)"`),
+		regexp.MustCompile(`add_argument\(\s*(?:['"]-\w['"]\s*,\s*)?['"]--([\w-]+)['"]`),
+		regexp.MustCompile(`\.(?:option|requiredOption)\(\s*['"](?:-\w,?\s*)?--([\w-]+)`),
+	}
+	migrationFilePattern = regexp.MustCompile(`(?i)(^|/)(migrations?|migrate|schema)/|\.sql$`)
+	// dropPatterns find statements that destroy data in migrations
+	dropPatterns = []*regexp.Regexp{
+		regexp.MustCompile("(?i)\\bdrop\\s+(column|table)\\s+(?:if\\s+exists\\s+)?[`\"\\[]?([\\w.]+)"),
+		regexp.MustCompile("(?i)\\brename\\s+(column)\\s+[`\"\\[]?(\\w+)"),
+		regexp.MustCompile(`\b(?:remove_(column)\s+:\w+,|drop_(table))\s+:(\w+)`),
+	}
+	downMigrationPattern = regexp.MustCompile(`(?i)(^|[._-])down([._-]|$)`)
+	majorVersionSuffix   = regexp.MustCompile(`/v(\d+)$`)
+)
+
+// detectBreakingChanges looks for removed exported Go identifiers, changed
+// exported Go signatures outside of commands and internal packages, removed
+// command line flags, migrations that drop
+// columns or tables and module path major version bumps.
+func detectBreakingChanges(files []fileDiff, symbols []symbolChange) []breakingChange {
+	var changes []breakingChange
+
+	for _, s := range symbols {
+		if !s.Exported || path.Ext(s.File) != ".go" || strings.HasSuffix(s.File, "_test.go") || isInternalPackage(s.File) ||
+			s.Package == "main" {
+			continue
+		}
+		switch {
+		case s.Change == "removed":
+			changes = append(changes, breakingChange{File: s.File, Reason: "removes exported " + s.Kind + " " + s.Name})
+		case s.SignatureChanged():
+			changes = append(changes, breakingChange{File: s.File, Reason: "changes the signature of exported " + s.Kind + " " + s.Name})
+		}
+	}
+
+	// Flags only count as removed when they are not defined again elsewhere
+	definedFlags := map[string]bool{}
+	removedFlags := map[string]string{}
+	for _, f := range files {
+		added, removed := changedLines(f)
+		for _, line := range added {
+			for _, name := range flagNames(line) {
+				definedFlags[name] = true
+			}
+		}
+		for _, line := range removed {
+			for _, name := range flagNames(line) {
+				removedFlags[name] = f.Path
+			}
+		}
+	}
+	for _, name := range sortedKeys(removedFlags) {
+		if !definedFlags[name] {
+			changes = append(changes, breakingChange{File: removedFlags[name], Reason: "removes the --" + name + " flag"})
+		}
+	}
+
+	for _, f := range files {
+		switch {
+		case migrationFilePattern.MatchString(f.Path) && !downMigrationPattern.MatchString(path.Base(f.Path)):
+			added, _ := changedLines(f)
+			for _, line := range added {
+				if strings.HasPrefix(strings.TrimSpace(line), "--") {
+					continue
+				}
+				for _, re := range dropPatterns {
+					if m := re.FindStringSubmatch(line); m != nil {
+						verb, object, name := "drops", firstNonEmpty(m[1:len(m)-1]), m[len(m)-1]
+						if strings.EqualFold(m[0][:6], "rename") {
+							verb = "renames"
+						}
+						changes = append(changes, breakingChange{File: f.Path, Reason: verb + " " + strings.ToLower(object) + " " + name})
+						break
+					}
+				}
+			}
+		case path.Base(f.Path) == "go.mod":
+			added, removed := changedLines(f)
+			before, after := modulePath(removed), modulePath(added)
+			if before != "" && after != "" && before != after && moduleMajor(after) > moduleMajor(before) {
+				changes = append(changes, breakingChange{File: f.Path, Reason: "bumps the module major version from " + before + " to " + after})
+			}
+		}
+	}
+	return changes
+}
+
+// flagNames returns the names of the flags defined on line.
+func flagNames(line string) []string {
+	var names []string
+	for _, re := range flagDefinitionPatterns {
+		for _, m := range re.FindAllStringSubmatch(line, -1) {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// isInternalPackage reports whether name is in an internal package, which
+// other modules cannot import.
+func isInternalPackage(name string) bool {
+	return strings.HasPrefix(name, "internal/") || strings.Contains(name, "/internal/")
+}
+
+func firstNonEmpty(values []string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// modulePath returns the module path declared by one of lines of a go.mod.
+func modulePath(lines []string) string {
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
+
+// moduleMajor returns the major version of a module path, which is 1 unless
+// it ends with a /vN suffix.
+func moduleMajor(module string) int {
+	m := majorVersionSuffix.FindStringSubmatch(module)
+	if m == nil {
+		return 1
+	}
+	major, _ := strconv.Atoi(m[1])
+	return major
+}
+
+// describeBreakingChanges lists the changes for the prompt.
+func describeBreakingChanges(changes []breakingChange) string {
+	var b strings.Builder
+	for _, c := range changes {
+		b.WriteString("- " + c.Reason + " (" + c.File + ")\n")
+	}
+	return b.String()
+}
+
+// applyBreakingChanges makes sure a message written by the heuristic provider
+// carries the breaking change marker and footer of the detected changes.
+// Models are told about them in the prompt and decide which ones are real.
+func applyBreakingChanges(msg *CommitMessage, changes []breakingChange) {
+	if len(changes) == 0 || msg.BreakingChange != "" {
+		return
+	}
+	var reasons []string
+	for _, c := range changes {
+		reasons = append(reasons, c.Reason)
+	}
+	msg.BreakingChange = capitalize(strings.Join(reasons, "; "))
+}
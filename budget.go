@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// BudgetConfig holds local spending limits, enforced from the usage log
+type BudgetConfig struct {
+	// Daily and Monthly are spend caps in USD, zero means unlimited
+	Daily   float64 `json:"daily,omitempty"`
+	Monthly float64 `json:"monthly,omitempty"`
+	// OnExceeded is "error" (default) or "downgrade" to switch to the
+	// provider's budget_model once a cap is reached
+	OnExceeded string `json:"on_exceeded,omitempty"`
+}
+
+// setBudgetValue sets a key of the budget config section.
+func setBudgetValue(budget *BudgetConfig, key, value string) error {
+	switch key {
+	case "daily", "monthly":
+		var limit float64
+		if value != "" {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 {
+				return fmt.Errorf("budget.%s must be a positive amount in USD", key)
+			}
+			limit = v
+		}
+		if key == "daily" {
+			budget.Daily = limit
+		} else {
+			budget.Monthly = limit
+		}
+	case "on_exceeded":
+		if value != "" && value != "error" && value != "downgrade" {
+			return fmt.Errorf("budget.on_exceeded must be error or downgrade")
+		}
+		budget.OnExceeded = value
+	default:
+		return fmt.Errorf("unknown key for budget: %s", key)
+	}
+	return nil
+}
+
+// getBudgetValue returns a key of the budget config section.
+func getBudgetValue(budget BudgetConfig, key string) (string, bool) {
+	switch key {
+	case "daily":
+		return formatLimit(budget.Daily), true
+	case "monthly":
+		return formatLimit(budget.Monthly), true
+	case "on_exceeded":
+		return budget.OnExceeded, true
+	}
+	return "", false
+}
+
+func formatLimit(limit float64) string {
+	if limit == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(limit, 'f', -1, 64)
+}
+
+// rateLimitError is returned when the request rate limit of a provider is
+// reached. Wait is the time until the oldest request of the last minute ages
+// out.
+type rateLimitError struct {
+	Provider Provider
+	Limit    int
+	Wait     time.Duration
+}
+
+func (e rateLimitError) Error() string {
+	return fmt.Sprintf("rate limit of %d requests per minute reached for %s, try again in %ds",
+		e.Limit, e.Provider, int(e.Wait.Seconds())+1)
+}
+
+// enforceBudget checks the spend caps and the request rate of provider
+// before a request is sent. When a spend cap is reached and downgrading is
+// enabled, pc.Model is switched to the cheaper budget model.
+func enforceBudget(cfg *Config, provider Provider, section string, pc *ProviderConfig) error {
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	records, err := loadUsage(monthStart)
+	if err != nil {
+		return err
+	}
+
+	// Only successful requests are logged, so failed attempts do not count
+	// towards the rate limit
+	if pc.MaxRequestsPerMinute > 0 {
+		var recent int
+		var oldest time.Time
+		for _, record := range records {
+			if Provider(record.Provider) == provider && now.Sub(record.Time) < time.Minute {
+				if recent == 0 || record.Time.Before(oldest) {
+					oldest = record.Time
+				}
+				recent++
+			}
+		}
+		if recent >= pc.MaxRequestsPerMinute {
+			return rateLimitError{Provider: provider, Limit: pc.MaxRequestsPerMinute, Wait: time.Minute - now.Sub(oldest)}
+		}
+	}
+
+	if cfg.Budget.Daily == 0 && cfg.Budget.Monthly == 0 {
+		return nil
+	}
+
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var daily, monthly float64
+	for _, record := range records {
+		if record.Cost < 0 {
+			continue
+		}
+		monthly += record.Cost
+		if !record.Time.Before(dayStart) {
+			daily += record.Cost
+		}
+	}
+
+	var exceeded string
+	switch {
+	case cfg.Budget.Daily > 0 && daily >= cfg.Budget.Daily:
+		exceeded = fmt.Sprintf("daily budget of $%.2f reached ($%.4f spent today)", cfg.Budget.Daily, daily)
+	case cfg.Budget.Monthly > 0 && monthly >= cfg.Budget.Monthly:
+		exceeded = fmt.Sprintf("monthly budget of $%.2f reached ($%.4f spent this month)", cfg.Budget.Monthly, monthly)
+	default:
+		return nil
+	}
+
+	if cfg.Budget.OnExceeded == "downgrade" && pc.BudgetModel != "" && pc.BudgetModel != pc.Model {
+		fmt.Fprintf(os.Stderr, "Warning: %s, using %s instead of %s\n", exceeded, pc.BudgetModel, pc.Model)
+		pc.Model = pc.BudgetModel
+		return nil
+	}
+	if cfg.Budget.OnExceeded == "downgrade" && pc.BudgetModel == "" {
+		return fmt.Errorf("%s and no cheaper model is configured, set one with:\n"+
+			"commitly config set %s.budget_model <model>", exceeded, section)
+	}
+	return fmt.Errorf("%s, raise it with:\n"+
+		"commitly config set budget.daily <usd>\n"+
+		"commitly config set budget.monthly <usd>", exceeded)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// promptVersion identifies the prompt templates. Bump it whenever the system
+// prompt or the prompt built in main changes so stale responses are not reused.
+const promptVersion = 5
+
+const (
+	defaultCacheTTL       = 7 * 24 * time.Hour
+	defaultCacheMaxSizeMB = 50
+)
+
+// CacheConfig holds settings for the response cache
+type CacheConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// TTL is a Go duration such as "24h", defaults to 7 days
+	TTL string `json:"ttl,omitempty"`
+	// MaxSizeMB caps the size of the cache directory, defaults to 50
+	MaxSizeMB int `json:"max_size_mb,omitempty"`
+}
+
+func (c CacheConfig) ttl() time.Duration {
+	if d, err := time.ParseDuration(c.TTL); err == nil && d > 0 {
+		return d
+	}
+	return defaultCacheTTL
+}
+
+func (c CacheConfig) maxSize() int64 {
+	if c.MaxSizeMB > 0 {
+		return int64(c.MaxSizeMB) << 20
+	}
+	return defaultCacheMaxSizeMB << 20
+}
+
+// setCacheValue sets a key of the cache config section.
+func setCacheValue(cache *CacheConfig, key, value string) error {
+	switch key {
+	case "enabled":
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("cache.enabled must be true or false")
+		}
+		cache.Disabled = !enabled
+	case "ttl":
+		if value != "" {
+			if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+				return fmt.Errorf("cache.ttl must be a positive duration such as 24h")
+			}
+		}
+		cache.TTL = value
+	case "max_size_mb":
+		if value == "" {
+			cache.MaxSizeMB = 0
+			return nil
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("cache.max_size_mb must be a positive integer")
+		}
+		cache.MaxSizeMB = v
+	default:
+		return fmt.Errorf("unknown key for cache: %s", key)
+	}
+	return nil
+}
+
+// getCacheValue returns a key of the cache config section.
+func getCacheValue(cache CacheConfig, key string) (string, bool) {
+	switch key {
+	case "enabled":
+		return strconv.FormatBool(!cache.Disabled), true
+	case "ttl":
+		return cache.ttl().String(), true
+	case "max_size_mb":
+		return strconv.FormatInt(cache.maxSize()>>20, 10), true
+	}
+	return "", false
+}
+
+// cacheEntry is a cached provider response
+type cacheEntry struct {
+	Created  time.Time  `json:"created"`
+	Provider Provider   `json:"provider"`
+	Model    string     `json:"model"`
+	Text     string     `json:"text"`
+	Usage    tokenUsage `json:"usage"`
+}
+
+func getCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(getDataDir(), "cache", "responses")
+	}
+	return filepath.Join(dir, "commitly", "responses")
+}
+
+// cacheKey hashes everything that determines a response: the provider, the
+// endpoint, model and generation parameters of pc, the prompt version and the
+// request itself, which embeds the diff and the ticket.
+func cacheKey(provider Provider, pc ProviderConfig, req generationRequest) string {
+	params, _ := json.Marshal(struct {
+		BaseURL         string
+		Temperature     *float64
+		TopP            *float64
+		MaxTokens       *int
+		Seed            *int64
+		Stop            []string
+		ReasoningEffort string
+		SafetySettings  map[string]string
+	}{pc.BaseURL, pc.Temperature, pc.TopP, pc.MaxTokens, pc.Seed, pc.Stop, pc.ReasoningEffort, pc.SafetySettings})
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00%s\x00%s\x00", provider, pc.Model, params, promptVersion, req.System, req.Prompt)
+	if req.Output != nil {
+		h.Write([]byte(req.Output.schemaText()))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lookupCache returns the cached response for key, if any and not expired.
+func lookupCache(cfg CacheConfig, key string) (*cacheEntry, bool) {
+	data, err := os.ReadFile(filepath.Join(getCacheDir(), key+".json"))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	if time.Since(entry.Created) > cfg.ttl() {
+		return nil, false
+	}
+	return &entry, true
+}
+
+// storeCache saves a response under key and evicts the oldest entries once
+// the cache grows past its size limit.
+func storeCache(cfg CacheConfig, key string, entry cacheEntry) error {
+	dir := getCacheDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("error creating cache directory: %v", err)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error serializing cache entry: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, key+".json"), data, 0600); err != nil {
+		return fmt.Errorf("error writing cache entry: %v", err)
+	}
+	return pruneCache(cfg)
+}
+
+type cacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func listCache() ([]cacheFile, error) {
+	entries, err := os.ReadDir(getCacheDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache directory: %v", err)
+	}
+	var files []cacheFile
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheFile{
+			path:    filepath.Join(getCacheDir(), entry.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	return files, nil
+}
+
+// pruneCache removes expired entries, then the oldest ones until the cache
+// fits in its size limit.
+func pruneCache(cfg CacheConfig) error {
+	files, err := listCache()
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	var total int64
+	var kept []cacheFile
+	for _, f := range files {
+		if time.Since(f.modTime) > cfg.ttl() {
+			os.Remove(f.path)
+			continue
+		}
+		total += f.size
+		kept = append(kept, f)
+	}
+	for i := 0; total > cfg.maxSize() && i < len(kept); i++ {
+		os.Remove(kept[i].path)
+		total -= kept[i].size
+	}
+	return nil
+}
+
+// runCache implements "commitly cache clear|stats".
+func runCache(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: commitly cache <clear|stats>")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "clear":
+		files, err := listCache()
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := os.Remove(f.path); err != nil {
+				return fmt.Errorf("error removing %s: %v", f.path, err)
+			}
+		}
+		fmt.Printf("Removed %d cached responses\n", len(files))
+	case "stats":
+		files, err := listCache()
+		if err != nil {
+			return err
+		}
+		var size int64
+		var expired int
+		var oldest, newest time.Time
+		for _, f := range files {
+			size += f.size
+			if time.Since(f.modTime) > cfg.Cache.ttl() {
+				expired++
+			}
+			if oldest.IsZero() || f.modTime.Before(oldest) {
+				oldest = f.modTime
+			}
+			if f.modTime.After(newest) {
+				newest = f.modTime
+			}
+		}
+		fmt.Printf("Cache directory: %s\n", getCacheDir())
+		fmt.Printf("Enabled: %t\n", !cfg.Cache.Disabled)
+		fmt.Printf("Entries: %d (%d expired)\n", len(files), expired)
+		fmt.Printf("Size: %.2f MB of %d MB\n", float64(size)/(1<<20), cfg.Cache.maxSize()>>20)
+		fmt.Printf("TTL: %s\n", cfg.Cache.ttl())
+		if len(files) > 0 {
+			fmt.Printf("Oldest entry: %s\n", oldest.Format(time.RFC3339))
+			fmt.Printf("Newest entry: %s\n", newest.Format(time.RFC3339))
+		}
+	default:
+		return fmt.Errorf("unknown cache command %q, expected clear or stats", args[0])
+	}
+	return nil
+}
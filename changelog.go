@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// changelogEntry is a commit as it appears in a changelog
+type changelogEntry struct {
+	Type           string       `json:"type,omitempty"`
+	Scope          string       `json:"scope,omitempty"`
+	Subject        string       `json:"subject"`
+	BreakingChange string       `json:"breaking_change,omitempty"`
+	Tickets        []ticketLink `json:"tickets,omitempty"`
+	Commit         string       `json:"commit"`
+}
+
+// Markdown renders the entry as a list item, e.g.
+// "- **billing:** add invoices ([ABC-1](...), 1a2b3c4)".
+func (e changelogEntry) Markdown(text string) string {
+	line := "- "
+	if e.Scope != "" {
+		line += "**" + e.Scope + ":** "
+	}
+	line += text
+	var refs []string
+	for _, t := range e.Tickets {
+		refs = append(refs, t.Markdown())
+	}
+	return line + " (" + strings.Join(append(refs, e.Commit), ", ") + ")"
+}
+
+// changelogSection groups the entries of related commit types
+type changelogSection struct {
+	Title   string           `json:"title"`
+	Entries []changelogEntry `json:"entries"`
+}
+
+// changelog describes the commits of a release
+type changelog struct {
+	Version  string             `json:"version"`
+	Date     string             `json:"date,omitempty"`
+	Breaking []changelogEntry   `json:"breaking_changes,omitempty"`
+	Sections []changelogSection `json:"sections"`
+}
+
+// changelogSections maps commit types to Keep a Changelog style sections, in
+// the order they are printed. Other holds the commits of any other type and
+// hidden sections are only included on request.
+var changelogSections = []struct {
+	Title  string
+	Types  []string
+	Hidden bool
+}{
+	{"Added", []string{"feat"}, false},
+	{"Fixed", []string{"fix"}, false},
+	{"Changed", []string{"refactor", "perf", "revert"}, false},
+	{"Documentation", []string{"docs"}, false},
+	{"Maintenance", []string{"chore", "test", "style", "build", "ci"}, true},
+	{"Other", nil, true},
+}
+
+// changelogEntries parses the messages of commits into entries. Scopes that
+// are tickets, as commitly writes them by default, are moved to the tickets.
+func changelogEntries(commits []commitInfo, ticketURL string, ticketKeys []string) []changelogEntry {
+	var entries []changelogEntry
+	for _, c := range commits {
+		msg := parseCommitText(c.Message)
+		entry := changelogEntry{Type: msg.Type, Scope: msg.Scope, Subject: msg.Subject, BreakingChange: msg.BreakingChange, Commit: c.SHA[:7]}
+
+		seen := map[string]bool{}
+		for _, id := range findTickets(c.Message, ticketKeys) {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			link := ticketLink{ID: id}
+			if ticketURL != "" {
+				link.URL = strings.ReplaceAll(ticketURL, "{ticket}", id)
+			}
+			entry.Tickets = append(entry.Tickets, link)
+		}
+		if seen[entry.Scope] {
+			entry.Scope = ""
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// buildChangelog groups commits, newest first, by section and then by scope.
+// Breaking changes are also listed on their own.
+func buildChangelog(commits []commitInfo, version, date, ticketURL string, ticketKeys []string, all bool) *changelog {
+	entries := changelogEntries(commits, ticketURL, ticketKeys)
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	notes := &changelog{Version: version, Date: date}
+	for _, e := range entries {
+		if e.BreakingChange != "" {
+			notes.Breaking = append(notes.Breaking, e)
+		}
+	}
+	for _, section := range changelogSections {
+		if section.Hidden && !all {
+			continue
+		}
+		var matched []changelogEntry
+		for _, e := range entries {
+			if containsFold(section.Types, e.Type) || (section.Types == nil && !knownCommitType(e.Type)) {
+				matched = append(matched, e)
+			}
+		}
+		sort.SliceStable(matched, func(i, j int) bool { return matched[i].Scope < matched[j].Scope })
+		if len(matched) > 0 {
+			notes.Sections = append(notes.Sections, changelogSection{Title: section.Title, Entries: matched})
+		}
+	}
+	return notes
+}
+
+// knownCommitType reports whether a changelog section lists commitType.
+func knownCommitType(commitType string) bool {
+	for _, section := range changelogSections {
+		if containsFold(section.Types, commitType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Markdown renders the changelog as a Keep a Changelog release section.
+func (c *changelog) Markdown() string {
+	var b strings.Builder
+	if c.Date != "" {
+		fmt.Fprintf(&b, "## [%s] - %s\n", c.Version, c.Date)
+	} else {
+		fmt.Fprintf(&b, "## [%s]\n", c.Version)
+	}
+	if len(c.Breaking) > 0 {
+		b.WriteString("\n### Breaking Changes\n\n")
+		for _, e := range c.Breaking {
+			b.WriteString(e.Markdown(e.BreakingChange) + "\n")
+		}
+	}
+	for _, section := range c.Sections {
+		fmt.Fprintf(&b, "\n### %s\n\n", section.Title)
+		for _, e := range section.Entries {
+			text := e.Subject
+			if e.BreakingChange != "" {
+				text = "**BREAKING** " + text
+			}
+			b.WriteString(e.Markdown(text) + "\n")
+		}
+	}
+	if len(c.Breaking) == 0 && len(c.Sections) == 0 {
+		b.WriteString("\nNo notable changes.\n")
+	}
+	return b.String()
+}
+
+const polishSystemPrompt = "You turn developer changelogs into release notes for the users of the software. " +
+	"Keep the markdown structure, the section headings, the ticket links and the commit hashes. " +
+	"Rewrite each entry as a clear, user-facing sentence, merge duplicate entries and drop purely internal ones. " +
+	"Never invent changes."
+
+// polishChangelog asks the model to rewrite the markdown changelog into
+// user-facing release notes. The changelog is redacted like a diff, so it is
+// sent without ticket links, which look like secrets to the entropy detector,
+// and the tickets are linked again in the reply.
+func polishChangelog(cfg *Config, notes *changelog, noCache bool) (string, *generationResult, error) {
+	provider, err := getProvider()
+	if err != nil {
+		return "", nil, err
+	}
+	if actual, _, _ := resolveProvider(cfg, provider); actual == ProviderHeuristic {
+		return "", nil, fmt.Errorf("--polish needs a model, the heuristic provider cannot rewrite text")
+	}
+	unlinked := *notes
+	unlinked.Breaking = unlinkTickets(notes.Breaking)
+	unlinked.Sections = nil
+	for _, section := range notes.Sections {
+		unlinked.Sections = append(unlinked.Sections, changelogSection{Title: section.Title, Entries: unlinkTickets(section.Entries)})
+	}
+	text, hits, err := redactText(unlinked.Markdown(), "changelog", cfg.Redaction)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(hits) > 0 {
+		if cfg.Redaction.Strict {
+			return "", nil, fmt.Errorf("refusing to send the changelog, found %d potential secrets: %s\n"+
+				"Reword those commits or disable strict mode with: commitly config set redaction.strict false",
+				len(hits), describeHits(hits))
+		}
+		fmt.Fprintf(os.Stderr, "Warning: redacted %d potential secrets before sending the changelog: %s\n",
+			len(hits), describeHits(hits))
+	}
+
+	req := generationRequest{
+		System:     polishSystemPrompt,
+		Prompt:     "Rewrite this changelog into release notes. Respond with the markdown only.\n\n" + text,
+		NoCache:    noCache,
+		Redactions: hits,
+	}
+	result, err := generateCommitMessage(req, provider)
+	if err != nil {
+		return "", nil, err
+	}
+	polished := strings.TrimSpace(stripCodeFence(result.Text)) + "\n"
+	return relinkTickets(polished, notes), result, nil
+}
+
+// relinkTickets links the tickets of the changelog entries again in text,
+// leaving alone links, URLs and code, where an ID is already linked or is
+// part of something else.
+func relinkTickets(text string, notes *changelog) string {
+	urls := map[string]string{}
+	entries := notes.Breaking
+	for _, section := range notes.Sections {
+		entries = append(entries, section.Entries...)
+	}
+	var ids []string
+	for _, e := range entries {
+		for _, t := range e.Tickets {
+			if t.URL != "" && urls[t.ID] == "" {
+				urls[t.ID] = t.URL
+				ids = append(ids, regexp.QuoteMeta(t.ID))
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return text
+	}
+	// Links, autolinks, URLs and code spans are matched first so that the
+	// IDs inside them are skipped
+	pattern := regexp.MustCompile(`\[[^\]]*\]\([^)]*\)|<[^>\s]*>|https?://\S+|` + "`[^`]*`" +
+		`|\b(?:` + strings.Join(ids, "|") + `)\b`)
+	return pattern.ReplaceAllStringFunc(text, func(match string) string {
+		if url, ok := urls[match]; ok {
+			return ticketLink{ID: match, URL: url}.Markdown()
+		}
+		return match
+	})
+}
+
+// unlinkTickets returns a copy of entries whose tickets have no URL.
+func unlinkTickets(entries []changelogEntry) []changelogEntry {
+	out := make([]changelogEntry, len(entries))
+	for i, e := range entries {
+		e.Tickets = nil
+		for _, t := range entries[i].Tickets {
+			e.Tickets = append(e.Tickets, ticketLink{ID: t.ID})
+		}
+		out[i] = e
+	}
+	return out
+}
+
+// commitDate returns the committer date of c in UTC.
+func commitDate(c commitInfo) time.Time {
+	seconds, _, _ := strings.Cut(c.CommitterDate, " ")
+	unix, _ := strconv.ParseInt(seconds, 10, 64)
+	return time.Unix(unix, 0).UTC()
+}
+
+// rangeCommits returns the commits of a range, oldest first, without merges.
+// When pathspecs are given, only the commits touching them are returned.
+func rangeCommits(spec string, pathspecs ...string) ([]commitInfo, error) {
+	if !strings.Contains(spec, "..") {
+		spec += "..HEAD"
+	}
+	return listCommits(spec, pathspecs...)
+}
+
+// listCommits returns the commits reachable from revs, as given to git
+// rev-list, oldest first and without merges.
+func listCommits(revs string, pathspecs ...string) ([]commitInfo, error) {
+	args := []string{"rev-list", "--reverse", "--no-merges", revs}
+	if len(pathspecs) > 0 {
+		args = append(append(args, "--"), pathspecs...)
+	}
+	output, err := runGit("", args...)
+	if err != nil {
+		return nil, err
+	}
+	var commits []commitInfo
+	for _, sha := range strings.Fields(output) {
+		c, err := readCommit(sha)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// runChangelog prints the changelog of a range of commits.
+func runChangelog(args []string) error {
+	fs := flag.NewFlagSet("changelog", flag.ExitOnError)
+	format := fs.String("format", "markdown", "output format: markdown or json")
+	version := fs.String("version", "Unreleased", "version the changes are released in")
+	all := fs.Bool("all", false, "also list maintenance and non-conventional commits")
+	polish := fs.Bool("polish", false, "rewrite the entries into user-facing release notes with the model")
+	output := fs.String("output", "", "write the changelog to this file instead of stdout")
+	noCache := fs.Bool("no-cache", false, "always call the provider instead of reusing a cached response")
+	fs.Usage = func() {
+		fmt.Println("Usage: commitly changelog [flags] <from>..<to>")
+		fmt.Println("Example: commitly changelog v1.2.0..HEAD")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *format != "markdown" && *format != "json" {
+		return fmt.Errorf("unknown format %q, expected markdown or json", *format)
+	}
+	if *polish && *format == "json" {
+		return fmt.Errorf("--polish only applies to the markdown format")
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	commits, err := rangeCommits(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// Unreleased changes have no date, releases the date of their last commit
+	date := ""
+	if *version != "Unreleased" && len(commits) > 0 {
+		date = commitDate(commits[len(commits)-1]).Format("2006-01-02")
+	}
+	notes := buildChangelog(commits, *version, date, cfg.PR.TicketURL, cfg.Commit.TicketKeys, *all)
+
+	var text string
+	var result *generationResult
+	switch {
+	case *format == "json":
+		data, err := json.MarshalIndent(notes, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error serializing changelog: %v", err)
+		}
+		text = string(data) + "\n"
+	case *polish:
+		if text, result, err = polishChangelog(cfg, notes, *noCache); err != nil {
+			return err
+		}
+	default:
+		text = notes.Markdown()
+	}
+
+	if *output != "" {
+		if err := os.WriteFile(*output, []byte(text), 0644); err != nil {
+			return fmt.Errorf("error writing %s: %v", *output, err)
+		}
+		fmt.Fprintf(os.Stderr, "Wrote the changelog of %d commits to %s\n", len(commits), *output)
+	} else {
+		fmt.Print(text)
+	}
+	if result != nil {
+		printUsageSummary(result)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestRelinkTickets(t *testing.T) {
+	notes := &changelog{
+		Version: "v1.2.0",
+		Sections: []changelogSection{{Title: "Added", Entries: []changelogEntry{
+			{Subject: "add the invoice export", Commit: "1a2b3c4", Tickets: []ticketLink{{ID: "ABC-1", URL: "https://jira.example.com/browse/ABC-1"}}},
+			{Subject: "add CSV columns", Commit: "5d6e7f8", Tickets: []ticketLink{{ID: "ABC-12", URL: "https://jira.example.com/browse/ABC-12"}}},
+		}}},
+	}
+	tests := []struct {
+		name, text, want string
+	}{
+		{
+			"plain IDs",
+			"- Export invoices (ABC-1, 1a2b3c4) and their columns (ABC-12)",
+			"- Export invoices ([ABC-1](https://jira.example.com/browse/ABC-1), 1a2b3c4) and their columns ([ABC-12](https://jira.example.com/browse/ABC-12))",
+		},
+		{
+			"already linked",
+			"- Export invoices ([ABC-1](https://jira.example.com/browse/ABC-1))",
+			"- Export invoices ([ABC-1](https://jira.example.com/browse/ABC-1))",
+		},
+		{
+			"inside a URL or code",
+			"- See https://wiki.example.com/ABC-1 and `ABC-12`",
+			"- See https://wiki.example.com/ABC-1 and `ABC-12`",
+		},
+		{
+			"not an entry ticket",
+			"- Names are read as UTF-8, see OPS-4",
+			"- Names are read as UTF-8, see OPS-4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := relinkTickets(tt.text, notes); got != tt.want {
+				t.Errorf("relinkTickets() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
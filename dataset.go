@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// emptyTree is the hash of git's empty tree, what a root commit is compared to
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+var datasetFormats = []string{"generic", "openai", "anthropic"}
+
+var messageQualities = []string{"any", "wellformed", "conventional"}
+
+// commitFilter selects the commits used as examples
+type commitFilter struct {
+	// Authors match the name or email of the author, case-insensitively
+	Authors []string
+	// Quality is "any", "wellformed" for the commits historyExamples would
+	// use, or "conventional" for well-formed conventional commits
+	Quality string
+}
+
+// skip returns why c is filtered out, or "" when it is kept.
+func (f commitFilter) skip(c commitInfo) string {
+	if len(f.Authors) > 0 {
+		author := strings.ToLower(c.AuthorName + " <" + c.AuthorEmail + ">")
+		matched := false
+		for _, a := range f.Authors {
+			matched = matched || strings.Contains(author, strings.ToLower(a))
+		}
+		if !matched {
+			return "author"
+		}
+	}
+	if f.Quality != "any" && !wellFormedCommit(c) {
+		return "quality"
+	}
+	if f.Quality == "conventional" && !knownCommitType(parseCommitText(c.Message).Type) {
+		return "quality"
+	}
+	return ""
+}
+
+// commitExample is a past commit as commitly would have generated its
+// message: the prepared diff, the request sent and the message actually
+// written
+type commitExample struct {
+	Commit    commitInfo
+	Diff      *preparedDiff
+	Ticket    string
+	Component string
+	Request   generationRequest
+	// Message is the human message, redacted like the diff
+	Message string
+}
+
+// buildCommitExample runs the diff of c through the generation pipeline:
+// privacy, truncation, redaction, symbols, breaking changes and scope. With
+// history, the prompt also shows the past commits before c. It returns why
+// the commit cannot be used instead when its diff is empty, or when secrets
+// are found in strict mode.
+func buildCommitExample(cfg *Config, c commitInfo, history bool) (*commitExample, string, error) {
+	raw, err := runGit("", "show", "--format=", "--no-color", "--no-ext-diff", c.SHA)
+	if err != nil {
+		return nil, "", err
+	}
+	before := emptyTree
+	if len(c.Parents) > 0 {
+		before = c.Parents[0]
+	}
+	diff, err := prepareDiff(cfg, raw, diffSource{Before: before, After: c.SHA})
+	if err != nil {
+		return nil, "", err
+	}
+	if strings.TrimSpace(diff.Text) == "" {
+		return nil, "empty diff", nil
+	}
+	message, hits, err := redactText(c.Message, "message", cfg.Redaction)
+	if err != nil {
+		return nil, "", err
+	}
+	if cfg.Redaction.Strict && len(diff.Redactions)+len(hits) > 0 {
+		return nil, "secrets", nil
+	}
+
+	ticket := findTicket(c.Message, cfg.Commit.TicketKeys)
+	component, _ := resolveScope(cfg.Scope, diff.Files)
+	text := ""
+	if history && len(c.Parents) > 0 {
+		if text, err = promptHistory(cfg, diff.Files, c.Parents[0]); err != nil {
+			return nil, "", err
+		}
+	}
+	req := commitRequest(diff, ticket, component, text)
+	req.Redactions = append(req.Redactions, hits...)
+	return &commitExample{Commit: c, Diff: diff, Ticket: ticket, Component: component, Request: req, Message: message}, "", nil
+}
+
+// chatMessage is a message of a chat fine-tuning record
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// datasetRecord returns the JSONL record of e in format: OpenAI chat
+// fine-tuning, Anthropic (system plus messages) or generic, which keeps the
+// diff and metadata for evaluation.
+func datasetRecord(e *commitExample, format string) any {
+	switch format {
+	case "openai":
+		return map[string]any{"messages": []chatMessage{
+			{Role: "system", Content: e.Request.System},
+			{Role: "user", Content: e.Request.Prompt},
+			{Role: "assistant", Content: e.Message},
+		}}
+	case "anthropic":
+		return map[string]any{
+			"system": e.Request.System,
+			"messages": []chatMessage{
+				{Role: "user", Content: e.Request.Prompt},
+				{Role: "assistant", Content: e.Message},
+			},
+		}
+	}
+	var files, truncated []string
+	for _, f := range e.Diff.Files {
+		files = append(files, f.Path)
+	}
+	for _, f := range e.Diff.Truncated {
+		truncated = append(truncated, f.Path)
+	}
+	return struct {
+		Commit     string   `json:"commit"`
+		Author     string   `json:"author"`
+		Date       string   `json:"date"`
+		Ticket     string   `json:"ticket,omitempty"`
+		Scope      string   `json:"scope,omitempty"`
+		Files      []string `json:"files"`
+		Withheld   []string `json:"withheld,omitempty"`
+		Truncated  []string `json:"truncated,omitempty"`
+		Redactions int      `json:"redactions"`
+		Diff       string   `json:"diff"`
+		System     string   `json:"system"`
+		Prompt     string   `json:"prompt"`
+		Message    string   `json:"message"`
+	}{
+		Commit:     e.Commit.SHA,
+		Author:     e.Commit.AuthorName + " <" + e.Commit.AuthorEmail + ">",
+		Date:       commitDate(e.Commit).Format("2006-01-02T15:04:05Z"),
+		Ticket:     e.Ticket,
+		Scope:      e.Component,
+		Files:      files,
+		Withheld:   e.Request.Withheld,
+		Truncated:  truncated,
+		Redactions: len(e.Request.Redactions),
+		Diff:       e.Diff.Text,
+		System:     e.Request.System,
+		Prompt:     e.Request.Prompt,
+		Message:    e.Message,
+	}
+}
+
+// runDataset implements "commitly dataset".
+func runDataset(args []string) error {
+	if len(args) == 0 || args[0] != "export" {
+		return fmt.Errorf("usage: commitly dataset export [flags] <range>")
+	}
+	fs := flag.NewFlagSet("dataset export", flag.ExitOnError)
+	format := fs.String("format", "generic", "record format: generic, openai (chat fine-tuning) or anthropic")
+	output := fs.String("output", "", "file to write, defaults to standard output")
+	authors := fs.String("author", "", "comma separated names or emails; only include commits by these authors")
+	paths := fs.String("path", "", "comma separated pathspecs; only include commits touching these paths")
+	quality := fs.String("quality", "wellformed", "message quality: any, wellformed (human, with a body) or conventional")
+	limit := fs.Int("limit", 0, "stop after this many records, 0 for no limit")
+	history := fs.Bool("history", false, "include the style examples and similar commits in the prompts, as generation does")
+	fs.Usage = func() {
+		fmt.Println("Usage: commitly dataset export [flags] <range>")
+		fmt.Println("Example: commitly dataset export --format openai --output train.jsonl v1.0.0..main")
+		fmt.Println("A single revision such as main exports its whole history.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if !containsFold(datasetFormats, *format) {
+		return fmt.Errorf("unknown format %q, expected one of: %s", *format, strings.Join(datasetFormats, ", "))
+	}
+	if !containsFold(messageQualities, *quality) {
+		return fmt.Errorf("unknown quality %q, expected one of: %s", *quality, strings.Join(messageQualities, ", "))
+	}
+	*format = strings.ToLower(*format)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	// Similar commits are ranked locally, the export sends nothing
+	cfg.Retrieval.Method = "bm25"
+	// A single revision exports its whole history
+	commits, err := listCommits(fs.Arg(0), splitList(*paths)...)
+	if err != nil {
+		return err
+	}
+	filter := commitFilter{Authors: splitList(*authors), Quality: strings.ToLower(*quality)}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %v", *output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+
+	exported := 0
+	skipped := map[string]int{}
+	// Newest first, so a limit keeps the most recent conventions
+	for i := len(commits) - 1; i >= 0 && (*limit <= 0 || exported < *limit); i-- {
+		c := commits[i]
+		if reason := filter.skip(c); reason != "" {
+			skipped[reason]++
+			continue
+		}
+		example, reason, err := buildCommitExample(cfg, c, *history)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			skipped[reason]++
+			continue
+		}
+		if err := enc.Encode(datasetRecord(example, *format)); err != nil {
+			return fmt.Errorf("error writing dataset: %v", err)
+		}
+		exported++
+	}
+
+	var reasons []string
+	total := 0
+	for _, reason := range sortedKeys(skipped) {
+		reasons = append(reasons, fmt.Sprintf("%d %s", skipped[reason], reason))
+		total += skipped[reason]
+	}
+	summary := fmt.Sprintf("Exported %d of %d commits", exported, len(commits))
+	if *output != "" {
+		summary += " to " + *output
+	}
+	if len(reasons) > 0 {
+		summary += fmt.Sprintf(", skipped %d: %s", total, strings.Join(reasons, ", "))
+	}
+	fmt.Fprintln(os.Stderr, summary)
+	return nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DiffConfig holds settings for the diff sent to a provider
+type DiffConfig struct {
+	// MaxFileLines truncates the diff of each file to this many lines, zero
+	// sends it whole
+	MaxFileLines int `json:"max_file_lines,omitempty"`
+}
+
+// setDiffValue sets a key of the diff config section.
+func setDiffValue(diff *DiffConfig, key, value string) error {
+	switch key {
+	case "max_file_lines":
+		if value == "" {
+			diff.MaxFileLines = 0
+			return nil
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("diff.max_file_lines must be a positive integer")
+		}
+		diff.MaxFileLines = v
+	default:
+		return fmt.Errorf("unknown key for diff: %s", key)
+	}
+	return nil
+}
+
+// getDiffValue returns a key of the diff config section.
+func getDiffValue(diff DiffConfig, key string) (string, bool) {
+	switch key {
+	case "max_file_lines":
+		if diff.MaxFileLines == 0 {
+			return "", true
+		}
+		return strconv.Itoa(diff.MaxFileLines), true
+	}
+	return "", false
+}
+
+// fileDiff is the part of a unified diff that concerns a single file
+type fileDiff struct {
+	Path    string
+	OldPath string
+	// Status is added, deleted, renamed or modified
+	Status  string
+	Binary  bool
+	Added   int
+	Removed int
+	// Text is the diff of the file, headers included
+	Text string
+}
+
+// Summary describes the change without its content, e.g.
+// "modified secrets/prod.yaml (+3 -1)".
+func (f fileDiff) Summary() string {
+	name := f.Path
+	if f.Status == "renamed" {
+		name = f.OldPath + " -> " + f.Path
+	}
+	if f.Binary {
+		return fmt.Sprintf("%s %s (binary)", f.Status, name)
+	}
+	return fmt.Sprintf("%s %s (+%d -%d)", f.Status, name, f.Added, f.Removed)
+}
+
+// parseDiff splits a unified git diff into per-file sections.
+func parseDiff(diff string) []fileDiff {
+	var files []fileDiff
+	var current *fileDiff
+	var text strings.Builder
+	inHeader := false
+
+	flush := func() {
+		if current != nil {
+			current.Text = text.String()
+			files = append(files, *current)
+		}
+		text.Reset()
+	}
+
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		trimmed := strings.TrimRight(line, "\n")
+		if strings.HasPrefix(trimmed, "diff --git ") {
+			flush()
+			current = &fileDiff{Status: "modified"}
+			current.OldPath, current.Path = parseDiffGitLine(trimmed)
+			inHeader = true
+		}
+		if current == nil {
+			continue
+		}
+		text.WriteString(line)
+
+		if inHeader {
+			switch {
+			case strings.HasPrefix(trimmed, "new file mode"):
+				current.Status = "added"
+			case strings.HasPrefix(trimmed, "deleted file mode"):
+				current.Status = "deleted"
+			case strings.HasPrefix(trimmed, "rename from "):
+				current.Status = "renamed"
+				current.OldPath = strings.TrimPrefix(trimmed, "rename from ")
+			case strings.HasPrefix(trimmed, "rename to "):
+				current.Path = strings.TrimPrefix(trimmed, "rename to ")
+			case strings.HasPrefix(trimmed, "Binary files "), trimmed == "GIT binary patch":
+				current.Binary = true
+			case strings.HasPrefix(trimmed, "--- "):
+				if p := strings.TrimPrefix(trimmed, "--- "); p != "/dev/null" {
+					current.OldPath = strings.TrimPrefix(p, "a/")
+				}
+			case strings.HasPrefix(trimmed, "+++ "):
+				if p := strings.TrimPrefix(trimmed, "+++ "); p != "/dev/null" {
+					current.Path = strings.TrimPrefix(p, "b/")
+				}
+			case strings.HasPrefix(trimmed, "@@"):
+				inHeader = false
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(trimmed, "+"):
+			current.Added++
+		case strings.HasPrefix(trimmed, "-"):
+			current.Removed++
+		}
+	}
+	flush()
+
+	for i := range files {
+		if files[i].Status == "deleted" {
+			files[i].Path = files[i].OldPath
+		}
+	}
+	return files
+}
+
+// parseDiffGitLine extracts the paths of a "diff --git a/x b/y" line.
+func parseDiffGitLine(line string) (string, string) {
+	rest := strings.TrimPrefix(line, "diff --git ")
+	if i := strings.Index(rest, " b/"); i >= 0 && strings.HasPrefix(rest, "a/") {
+		return rest[2:i], rest[i+3:]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) != 2 {
+		return rest, rest
+	}
+	return strings.TrimPrefix(fields[0], "a/"), strings.TrimPrefix(fields[1], "b/")
+}
+
+// changedLines returns the content of the added and removed lines of f,
+// without the leading "+" or "-".
+func changedLines(f fileDiff) (added, removed []string) {
+	inHunk := false
+	for _, line := range strings.Split(f.Text, "\n") {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			inHunk = true
+		case !inHunk:
+		case strings.HasPrefix(line, "+"):
+			added = append(added, line[1:])
+		case strings.HasPrefix(line, "-"):
+			removed = append(removed, line[1:])
+		}
+	}
+	return added, removed
+}
+
+// truncateFile cuts the diff of f after maxLines lines and reports whether
+// anything was cut.
+func truncateFile(f fileDiff, maxLines int) (fileDiff, bool) {
+	lines := strings.SplitAfter(f.Text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if maxLines <= 0 || len(lines) <= maxLines {
+		return f, false
+	}
+	f.Text = strings.Join(lines[:maxLines], "") +
+		fmt.Sprintf("... [%d more lines truncated]\n", len(lines)-maxLines)
+	return f, true
+}
+
+// joinDiff reassembles per-file sections into a single diff.
+func joinDiff(files []fileDiff) string {
+	var b strings.Builder
+	for _, f := range files {
+		b.WriteString(f.Text)
+	}
+	return b.String()
+}
+
+// preparedDiff is a diff ready to be sent to a provider, along with what
+// was removed from it on the way
+type preparedDiff struct {
+	Text string
+	// Files are the sections of the diff before redaction, for local
+	// analysis only
+	Files []fileDiff
+	// Withheld files were replaced by a metadata line by the privacy policy
+	Withheld []fileDiff
+	// Truncated files were cut to diff.max_file_lines
+	Truncated []fileDiff
+	// Symbols are the declarations changed in the files that were not
+	// withheld, and SymbolSummary their redacted description for the prompt
+	Symbols       []symbolChange
+	SymbolSummary string
+	// Breaking are the likely breaking changes, with redacted reasons
+	Breaking []breakingChange
+	// Redactions are the secrets masked in the diff and the summaries
+	Redactions []redactionHit
+}
+
+// prepareDiff applies the privacy policy, truncation and then secret
+// redaction to a raw git diff read from source, summarizes the changed
+// symbols and detects breaking changes. Every diff sent to a provider goes
+// through it.
+func prepareDiff(cfg *Config, raw string, source diffSource) (*preparedDiff, error) {
+	files, withheld, err := applyPrivacy(parseDiff(raw), cfg.Privacy)
+	if err != nil {
+		return nil, err
+	}
+
+	var truncated []fileDiff
+	sent := make([]fileDiff, len(files))
+	for i, f := range files {
+		var cut bool
+		if sent[i], cut = truncateFile(f, cfg.Diff.MaxFileLines); cut {
+			truncated = append(truncated, f)
+		}
+	}
+
+	text, hits, err := redactDiff(joinDiff(sent), cfg.Redaction)
+	if err != nil {
+		return nil, err
+	}
+
+	// Whole files are read for the symbols, so withheld ones are skipped
+	private := map[string]bool{}
+	for _, f := range withheld {
+		private[f.Path] = true
+	}
+	var public []fileDiff
+	for _, f := range files {
+		if !private[f.Path] {
+			public = append(public, f)
+		}
+	}
+	symbols := extractSymbols(source, public)
+	summary, summaryHits, err := redactText(summarizeSymbols(symbols), "symbol summary", cfg.Redaction)
+	if err != nil {
+		return nil, err
+	}
+	hits = append(hits, summaryHits...)
+
+	breaking := detectBreakingChanges(public, symbols)
+	for i, c := range breaking {
+		reason, reasonHits, err := redactText(c.Reason, "breaking changes", cfg.Redaction)
+		if err != nil {
+			return nil, err
+		}
+		breaking[i].Reason = reason
+		hits = append(hits, reasonHits...)
+	}
+
+	return &preparedDiff{
+		Text:          text,
+		Files:         files,
+		Withheld:      withheld,
+		Truncated:     truncated,
+		Symbols:       symbols,
+		SymbolSummary: summary,
+		Breaking:      breaking,
+		Redactions:    hits,
+	}, nil
+}
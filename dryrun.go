@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// charsPerToken approximates how many characters of code and English text a
+// token covers with the tokenizer of each provider.
+var charsPerToken = map[Provider]float64{
+	ProviderOpenAI:   4.0,
+	ProviderClaude:   3.5,
+	ProviderDeepseek: 3.8,
+	ProviderGemini:   4.0,
+}
+
+// estimateTokens returns a rough token count of text for provider.
+func estimateTokens(provider Provider, text string) int {
+	ratio, ok := charsPerToken[provider]
+	if !ok {
+		ratio = 4.0
+	}
+	return int(math.Ceil(float64(len(text)) / ratio))
+}
+
+// printDryRun prints everything that would be sent for req, and what it
+// would cost, without calling a provider.
+func printDryRun(cfg *Config, req generationRequest, provider Provider, diff *preparedDiff) {
+	actualProvider, pc, _ := resolveProvider(cfg, provider)
+	model := pc.Model
+	if model == "" {
+		model = defaultModels[actualProvider]
+	}
+	structured := req.Output != nil && supportsStructuredOutput(actualProvider, model)
+
+	fmt.Println("\nDry run, nothing is sent to a provider.")
+	fmt.Printf("\nProvider: %s/%s (structured output: %t)\n", actualProvider, model, structured)
+
+	fmt.Println("\n--- System prompt ---")
+	fmt.Println(req.systemFor(actualProvider, structured))
+	fmt.Println("\n--- User prompt ---")
+	fmt.Println(req.promptFor(structured))
+	if structured && actualProvider != ProviderDeepseek {
+		fmt.Println("\n--- Response schema ---")
+		fmt.Println(req.Output.schemaText())
+	}
+
+	fmt.Println("\n--- Diff ---")
+	var added, removed int
+	for _, f := range diff.Files {
+		added += f.Added
+		removed += f.Removed
+	}
+	fmt.Printf("%d files changed, +%d -%d, %d bytes sent\n", len(diff.Files), added, removed, len(diff.Text))
+	for _, f := range diff.Files {
+		fmt.Printf("  %s\n", f.Summary())
+	}
+	if len(diff.Withheld) > 0 {
+		fmt.Println("Withheld by privacy policy:")
+		for _, f := range diff.Withheld {
+			fmt.Printf("  %s\n", f.Path)
+		}
+	}
+	if len(diff.Truncated) > 0 {
+		fmt.Printf("Truncated to %d lines:\n", cfg.Diff.MaxFileLines)
+		for _, f := range diff.Truncated {
+			fmt.Printf("  %s\n", f.Path)
+		}
+	}
+	if len(diff.Redactions) > 0 {
+		byFile := map[string][]redactionHit{}
+		for _, hit := range diff.Redactions {
+			byFile[hit.File] = append(byFile[hit.File], hit)
+		}
+		fmt.Println("Redacted:")
+		for _, file := range sortedKeys(byFile) {
+			fmt.Printf("  %s: %s\n", file, summarizeHits(byFile[file]))
+		}
+	}
+
+	if len(diff.Breaking) > 0 {
+		fmt.Println("Likely breaking changes:")
+		for _, c := range diff.Breaking {
+			fmt.Printf("  %s (%s)\n", c.Reason, c.File)
+		}
+	}
+
+	fmt.Println("\n--- Estimated input tokens ---")
+	for _, p := range []Provider{ProviderOpenAI, ProviderClaude, ProviderDeepseek, ProviderGemini} {
+		actual, pc, section := resolveProvider(cfg, p)
+		model := pc.Model
+		if model == "" {
+			model = defaultModels[actual]
+		}
+		structured := req.Output != nil && supportsStructuredOutput(actual, model)
+		text := req.systemFor(actual, structured) + req.promptFor(structured)
+		if structured && actual != ProviderDeepseek {
+			text += req.Output.schemaText()
+		}
+		tokens := estimateTokens(actual, text)
+
+		line := fmt.Sprintf("  %-9s %-40s ~%6d tokens  %s", section, string(actual)+"/"+model, tokens,
+			formatCost(estimateCost(cfg, model, tokenUsage{InputTokens: tokens})))
+		if err := cfg.Policy.check(actual, section, pc); err != nil {
+			line += "  (not allowed by policy)"
+		}
+		if p == provider {
+			line += "  <- selected"
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+	fmt.Println("Token counts are estimates and costs exclude output tokens.")
+}
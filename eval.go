@@ -0,0 +1,470 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ProviderFake answers with the human message of the commit, so the
+// evaluation harness can be checked offline. It scores perfectly by design.
+const ProviderFake Provider = "fake"
+
+var evalProviders = []Provider{ProviderOpenAI, ProviderClaude, ProviderDeepseek, ProviderGemini, ProviderHeuristic, ProviderFake}
+
+// evalModel is a provider and optionally a model, the configured one when empty
+type evalModel struct {
+	Provider Provider
+	Model    string
+}
+
+// parseEvalModels parses a comma separated list such as
+// "openai/gpt-4o-mini,claude,heuristic".
+func parseEvalModels(spec string) ([]evalModel, error) {
+	var models []evalModel
+	for _, item := range splitList(spec) {
+		name, model, _ := strings.Cut(item, "/")
+		provider := Provider(strings.ToLower(name))
+		known := false
+		for _, p := range evalProviders {
+			known = known || p == provider
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown provider %q, expected one of: openai, claude, deepseek, gemini, heuristic, fake", name)
+		}
+		models = append(models, evalModel{Provider: provider, Model: model})
+	}
+	return models, nil
+}
+
+// label names the provider and model expected to serve m, as results are
+// labelled.
+func (m evalModel) label(cfg *Config) string {
+	if m.Provider == ProviderFake || m.Provider == ProviderHeuristic {
+		return string(m.Provider)
+	}
+	actual, pc, _ := resolveProvider(cfg, m.Provider)
+	model := m.Model
+	if model == "" {
+		model = pc.Model
+	}
+	if model == "" {
+		model = defaultModels[actual]
+	}
+	return string(actual) + "/" + model
+}
+
+// configuredModels returns the providers that have an API key or a base URL,
+// and the heuristic provider as a baseline.
+func configuredModels(cfg *Config) []evalModel {
+	var models []evalModel
+	for _, p := range []Provider{ProviderOpenAI, ProviderClaude, ProviderDeepseek, ProviderGemini} {
+		if _, pc, _ := resolveProvider(cfg, p); getAPIKey(p) != "" || pc.BaseURL != "" {
+			models = append(models, evalModel{Provider: p})
+		}
+	}
+	return append(models, evalModel{Provider: ProviderHeuristic})
+}
+
+// evalResult is how the message generated by a model for a commit compares
+// to the message actually written
+type evalResult struct {
+	Commit string `json:"commit"`
+	Model  string `json:"model"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Valid is set for a conventional header of a known type that fits in 72
+	// characters
+	Valid bool `json:"valid"`
+	// TypeMatch and TicketPresent are nil when the human message has no
+	// conventional type or no ticket
+	TypeMatch     *bool `json:"type_match,omitempty"`
+	TicketPresent *bool `json:"ticket_present,omitempty"`
+	// Length is the ratio of the shorter to the longer message
+	Length float64 `json:"length"`
+	// Lexical is the F1 score of the words of both messages
+	Lexical float64 `json:"lexical"`
+	// Semantic is the cosine similarity of their embeddings, with --semantic
+	Semantic     *float64 `json:"semantic,omitempty"`
+	LatencyMS    int64    `json:"latency_ms"`
+	InputTokens  int      `json:"input_tokens"`
+	OutputTokens int      `json:"output_tokens"`
+	Cost         float64  `json:"cost"`
+}
+
+// evalSummary aggregates the results of a model. Rates and averages are over
+// the commits that succeeded and to which they apply, and nil when there are
+// none.
+type evalSummary struct {
+	Model          string   `json:"model"`
+	Samples        int      `json:"samples"`
+	Errors         int      `json:"errors"`
+	Valid          *float64 `json:"valid,omitempty"`
+	TypeAgreement  *float64 `json:"type_agreement,omitempty"`
+	TicketPresence *float64 `json:"ticket_presence,omitempty"`
+	Length         *float64 `json:"length,omitempty"`
+	Lexical        *float64 `json:"lexical,omitempty"`
+	Semantic       *float64 `json:"semantic,omitempty"`
+	LatencyMS      int64    `json:"avg_latency_ms"`
+	// Cost is the total, negative when a model has no known price
+	Cost float64 `json:"cost"`
+}
+
+// evalReport is the JSON report of commitly eval
+type evalReport struct {
+	Range   string        `json:"range"`
+	Commits int           `json:"commits"`
+	Models  []evalSummary `json:"models"`
+	Results []evalResult  `json:"results"`
+}
+
+// lexicalSimilarity returns the F1 score of the words of a and b.
+func lexicalSimilarity(a, b string) float64 {
+	counts := map[string]int{}
+	wordsA, wordsB := tokenize(a), tokenize(b)
+	for _, w := range wordsA {
+		counts[w]++
+	}
+	common := 0
+	for _, w := range wordsB {
+		if counts[w] > 0 {
+			counts[w]--
+			common++
+		}
+	}
+	if common == 0 {
+		return 0
+	}
+	precision := float64(common) / float64(len(wordsB))
+	recall := float64(common) / float64(len(wordsA))
+	return 2 * precision * recall / (precision + recall)
+}
+
+// lengthRatio returns the length of the shorter of a and b over the longer.
+func lengthRatio(a, b string) float64 {
+	la, lb := len([]rune(strings.TrimSpace(a))), len([]rune(strings.TrimSpace(b)))
+	if la == 0 || lb == 0 {
+		return 0
+	}
+	return float64(min(la, lb)) / float64(max(la, lb))
+}
+
+// scoreOutput compares a generated message to the human one of example.
+func scoreOutput(example *commitExample, msg *CommitMessage, output string) evalResult {
+	result := evalResult{
+		Output:  output,
+		Valid:   knownCommitType(msg.Type) && msg.Subject != "" && len(msg.Header()) <= 72,
+		Length:  lengthRatio(example.Message, output),
+		Lexical: lexicalSimilarity(example.Message, output),
+	}
+	if human := parseCommitText(example.Message); knownCommitType(human.Type) {
+		match := human.Type == msg.Type
+		result.TypeMatch = &match
+	}
+	if example.Ticket != "" {
+		present := strings.Contains(output, example.Ticket)
+		result.TicketPresent = &present
+	}
+	return result
+}
+
+// generateForEval generates the message of example with model and scores it.
+// Results are labelled with the model that actually served them, which the
+// budget may have switched to a cheaper one. Rate limits are waited out.
+func generateForEval(cfg *Config, example *commitExample, model evalModel, useCache bool) evalResult {
+	var result *generationResult
+	var err error
+	var latency time.Duration
+	for {
+		start := time.Now()
+		if model.Provider == ProviderFake {
+			result = &generationResult{Text: example.Message, Provider: ProviderFake}
+		} else {
+			req := example.Request
+			req.Model, req.NoCache = model.Model, !useCache
+			result, err = generateCommitMessage(req, model.Provider)
+		}
+		latency = time.Since(start)
+		var limited rateLimitError
+		if !errors.As(err, &limited) {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "Rate limit of %s reached, waiting %ds\n", limited.Provider, int(limited.Wait.Seconds())+1)
+		time.Sleep(limited.Wait + time.Second)
+	}
+	label := model.label(cfg)
+	if err != nil {
+		return evalResult{Commit: example.Commit.SHA, Model: label, Error: err.Error(), LatencyMS: latency.Milliseconds()}
+	}
+	if result.Provider != ProviderFake && result.Provider != ProviderHeuristic {
+		label = string(result.Provider) + "/" + result.Model
+	}
+
+	msg, output, err := finishCommitMessage(cfg, result, example.Diff, example.Ticket, example.Component)
+	if err != nil {
+		return evalResult{Commit: example.Commit.SHA, Model: label, Error: err.Error(), LatencyMS: latency.Milliseconds()}
+	}
+	scored := scoreOutput(example, msg, output)
+	scored.Commit, scored.Model = example.Commit.SHA, label
+	scored.LatencyMS = latency.Milliseconds()
+	scored.InputTokens, scored.OutputTokens, scored.Cost = result.Usage.InputTokens, result.Usage.OutputTokens, result.Cost
+	return scored
+}
+
+// addSemanticScores embeds the human message of a commit and its outputs in
+// one request and sets their cosine similarity.
+func addSemanticScores(cfg *Config, human string, results []evalResult) error {
+	provider, pc, err := embedder(cfg)
+	if err != nil {
+		return err
+	}
+	texts := []string{human}
+	var scored []int
+	for i, r := range results {
+		if r.Error == "" {
+			texts = append(texts, r.Output)
+			scored = append(scored, i)
+		}
+	}
+	if len(scored) == 0 {
+		return nil
+	}
+	for i, text := range texts {
+		redacted, _, err := redactText(text, "message", cfg.Redaction)
+		if err != nil {
+			return err
+		}
+		texts[i] = redacted
+	}
+	vectors, err := embed(cfg, provider, pc, texts)
+	if err != nil {
+		return err
+	}
+	for j, i := range scored {
+		similarity := cosineSimilarity(vectors[0], vectors[j+1])
+		results[i].Semantic = &similarity
+	}
+	return nil
+}
+
+// summarizeEval aggregates the results of model.
+func summarizeEval(model string, results []evalResult) evalSummary {
+	summary := evalSummary{Model: model}
+	var valid, types, typeMatches, tickets, ticketMatches, semantics int
+	var length, lexical, semantic float64
+	var latency int64
+	for _, r := range results {
+		if r.Model != model {
+			continue
+		}
+		summary.Samples++
+		latency += r.LatencyMS
+		if r.Error != "" {
+			summary.Errors++
+			continue
+		}
+		if r.Valid {
+			valid++
+		}
+		if r.TypeMatch != nil {
+			types++
+			if *r.TypeMatch {
+				typeMatches++
+			}
+		}
+		if r.TicketPresent != nil {
+			tickets++
+			if *r.TicketPresent {
+				ticketMatches++
+			}
+		}
+		if r.Semantic != nil {
+			semantics++
+			semantic += *r.Semantic
+		}
+		length += r.Length
+		lexical += r.Lexical
+		if r.Cost < 0 || summary.Cost < 0 {
+			summary.Cost = -1
+		} else {
+			summary.Cost += r.Cost
+		}
+	}
+	if summary.Samples > 0 {
+		summary.LatencyMS = latency / int64(summary.Samples)
+	}
+	succeeded := summary.Samples - summary.Errors
+	average := func(sum float64, n int) *float64 {
+		if n == 0 {
+			return nil
+		}
+		v := sum / float64(n)
+		return &v
+	}
+	summary.Valid = average(float64(valid), succeeded)
+	summary.TypeAgreement = average(float64(typeMatches), types)
+	summary.TicketPresence = average(float64(ticketMatches), tickets)
+	summary.Length = average(length, succeeded)
+	summary.Lexical = average(lexical, succeeded)
+	summary.Semantic = average(semantic, semantics)
+	return summary
+}
+
+// printEvalTable writes the summaries as a comparison table.
+func printEvalTable(w io.Writer, report evalReport) {
+	percent := func(v *float64) string {
+		if v == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.0f%%", *v*100)
+	}
+	score := func(v *float64) string {
+		if v == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f", *v)
+	}
+	fmt.Fprintf(w, "Evaluated %d commits of %s\n\n", report.Commits, report.Range)
+	fmt.Fprintf(w, "%-32s %6s %6s %6s %6s %7s %8s %8s %10s %6s\n",
+		"Model", "Valid", "Type", "Ticket", "Length", "Lexical", "Semantic", "Latency", "Cost", "Errors")
+	for _, s := range report.Models {
+		latency := (time.Duration(s.LatencyMS) * time.Millisecond).Round(10 * time.Millisecond)
+		fmt.Fprintf(w, "%-32s %6s %6s %6s %6s %7s %8s %8s %10s %6d\n",
+			s.Model, percent(s.Valid), percent(s.TypeAgreement), percent(s.TicketPresence), score(s.Length), score(s.Lexical),
+			score(s.Semantic), latency, formatCost(s.Cost), s.Errors)
+	}
+}
+
+// runEval replays past commits through providers and models and compares
+// the messages they generate to the ones actually written.
+func runEval(args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	modelsFlag := fs.String("models", "", "comma separated provider or provider/model list, e.g. openai/gpt-4o-mini,claude,fake (default every configured provider and heuristic)")
+	n := fs.Int("n", 20, "number of commits to replay, the newest of the range")
+	format := fs.String("format", "table", "report format: table or json")
+	output := fs.String("output", "", "file to write the report to, defaults to standard output")
+	authors := fs.String("author", "", "comma separated names or emails; only replay commits by these authors")
+	paths := fs.String("path", "", "comma separated pathspecs; only replay commits touching these paths")
+	quality := fs.String("quality", "wellformed", "message quality of the commits replayed: any, wellformed or conventional")
+	history := fs.Bool("history", true, "include the style examples and similar commits in the prompts, as generation does")
+	semantic := fs.Bool("semantic", false, "also score semantic similarity with the embeddings of the provider")
+	useCache := fs.Bool("cache", false, "reuse cached responses, which makes latencies meaningless")
+	fs.Usage = func() {
+		fmt.Println("Usage: commitly eval [flags] [<range>]")
+		fmt.Println("Example: commitly eval --n 50 --models openai/gpt-4o,openai/gpt-4o-mini,claude main")
+		fmt.Println("The range defaults to HEAD; a single revision replays its history.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 || *n <= 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q, expected table or json", *format)
+	}
+	if !containsFold(messageQualities, *quality) {
+		return fmt.Errorf("unknown quality %q, expected one of: %s", *quality, strings.Join(messageQualities, ", "))
+	}
+	spec := "HEAD"
+	if fs.NArg() == 1 {
+		spec = fs.Arg(0)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	// Similar commits are ranked locally, so that building the prompts sends
+	// nothing and the fake and heuristic providers work offline
+	cfg.Retrieval.Method = "bm25"
+	models := configuredModels(cfg)
+	if *modelsFlag != "" {
+		if models, err = parseEvalModels(*modelsFlag); err != nil {
+			return err
+		}
+	}
+	commits, err := listCommits(spec, splitList(*paths)...)
+	if err != nil {
+		return err
+	}
+
+	// Build the examples first, newest first, so every model sees the same ones
+	filter := commitFilter{Authors: splitList(*authors), Quality: strings.ToLower(*quality)}
+	var examples []*commitExample
+	for i := len(commits) - 1; i >= 0 && len(examples) < *n; i-- {
+		if filter.skip(commits[i]) != "" {
+			continue
+		}
+		example, reason, err := buildCommitExample(cfg, commits[i], *history)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			examples = append(examples, example)
+		}
+	}
+	if len(examples) == 0 {
+		return fmt.Errorf("no commits of %s match the filters", spec)
+	}
+	var names []string
+	for _, m := range models {
+		if label := m.label(cfg); !containsFold(names, label) {
+			names = append(names, label)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Replaying %d commits with %s\n", len(examples), strings.Join(names, ", "))
+
+	report := evalReport{Range: spec, Commits: len(examples)}
+	for i, example := range examples {
+		fmt.Fprintf(os.Stderr, "[%d/%d] %.7s %s\n", i+1, len(examples), example.Commit.SHA, example.Commit.Subject())
+		var results []evalResult
+		for _, model := range models {
+			result := generateForEval(cfg, example, model, *useCache)
+			if result.Error != "" {
+				fmt.Fprintf(os.Stderr, "Warning: %s failed: %s\n", result.Model, strings.SplitN(result.Error, "\n", 2)[0])
+			}
+			if !containsFold(names, result.Model) {
+				names = append(names, result.Model)
+			}
+			results = append(results, result)
+		}
+		if *semantic {
+			if err := addSemanticScores(cfg, example.Message, results); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: no semantic similarity for %.7s: %v\n", example.Commit.SHA, err)
+			}
+		}
+		report.Results = append(report.Results, results...)
+	}
+	for _, name := range names {
+		report.Models = append(report.Models, summarizeEval(name, report.Results))
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.OpenFile(*output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %v", *output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return fmt.Errorf("error writing report: %v", err)
+		}
+	} else {
+		printEvalTable(w, report)
+	}
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "Wrote the report to %s\n", *output)
+	}
+	return nil
+}
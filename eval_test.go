@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestLexicalSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"add the invoice export", "add the invoice export", 1},
+		{"add invoice export", "remove user import", 0},
+		{"add invoice export", "add invoice", 0.8},
+		// Repeated words only match as often as they appear on both sides
+		{"fix fix fix", "fix", 0.5},
+		{"", "add invoice export", 0},
+	}
+	for _, tt := range tests {
+		if got := lexicalSimilarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("lexicalSimilarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestLengthRatio(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"abcd", "abcd", 1},
+		{"ab", "abcd", 0.5},
+		{"abcd", "  ab\n", 0.5},
+		{"", "abcd", 0},
+	}
+	for _, tt := range tests {
+		if got := lengthRatio(tt.a, tt.b); got != tt.want {
+			t.Errorf("lengthRatio(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestScoreOutput(t *testing.T) {
+	conventional := &commitExample{Message: "feat(ABC-1): add the invoice export\n\n- Export invoices as CSV", Ticket: "ABC-1"}
+	plain := &commitExample{Message: "Add the invoice export"}
+	tests := []struct {
+		name      string
+		example   *commitExample
+		output    string
+		valid     bool
+		typeMatch *bool
+		ticket    *bool
+	}{
+		{"same message", conventional, conventional.Message, true, ptr(true), ptr(true)},
+		{"other type", conventional, "fix(ABC-1): export invoices", true, ptr(false), ptr(true)},
+		{"missing ticket", conventional, "feat: add the invoice export", true, ptr(true), ptr(false)},
+		{"unknown type", conventional, "update(ABC-1): add the invoice export", false, ptr(false), ptr(true)},
+		{"long header", conventional, "feat(ABC-1): add the invoice export with every column the accounting team asked for", false, ptr(true), ptr(true)},
+		{"not conventional", conventional, "Add the invoice export", false, ptr(false), ptr(false)},
+		{"human not conventional", plain, "feat: add the invoice export", true, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scoreOutput(tt.example, parseCommitText(tt.output), tt.output)
+			if got.Valid != tt.valid {
+				t.Errorf("Valid = %v, want %v", got.Valid, tt.valid)
+			}
+			if !equalPtr(got.TypeMatch, tt.typeMatch) {
+				t.Errorf("TypeMatch = %v, want %v", show(got.TypeMatch), show(tt.typeMatch))
+			}
+			if !equalPtr(got.TicketPresent, tt.ticket) {
+				t.Errorf("TicketPresent = %v, want %v", show(got.TicketPresent), show(tt.ticket))
+			}
+			if tt.output == tt.example.Message && (got.Lexical != 1 || got.Length != 1) {
+				t.Errorf("Lexical, Length = %v, %v for the human message, want 1, 1", got.Lexical, got.Length)
+			}
+		})
+	}
+}
+
+func TestSummarizeEval(t *testing.T) {
+	results := []evalResult{
+		{Model: "a", Valid: true, TypeMatch: ptr(true), Length: 1, Lexical: 0.5, LatencyMS: 100, Cost: 0.01},
+		{Model: "a", Valid: false, TypeMatch: ptr(false), TicketPresent: ptr(true), Length: 0.5, Lexical: 0.25, LatencyMS: 300, Cost: 0.02},
+		{Model: "a", Error: "timeout", LatencyMS: 200},
+		{Model: "b", Valid: true, Length: 1, Lexical: 1, Semantic: ptr(0.8), Cost: -1},
+		{Model: "c", Error: "no API key"},
+	}
+
+	a := summarizeEval("a", results)
+	if a.Samples != 3 || a.Errors != 1 || a.LatencyMS != 200 {
+		t.Errorf("a: samples, errors, latency = %d, %d, %d, want 3, 1, 200", a.Samples, a.Errors, a.LatencyMS)
+	}
+	for name, c := range map[string]struct{ got, want *float64 }{
+		"valid":           {a.Valid, ptr(0.5)},
+		"type agreement":  {a.TypeAgreement, ptr(0.5)},
+		"ticket presence": {a.TicketPresence, ptr(1.0)},
+		"length":          {a.Length, ptr(0.75)},
+		"lexical":         {a.Lexical, ptr(0.375)},
+		"semantic":        {a.Semantic, nil},
+	} {
+		if !equalPtr(c.got, c.want) {
+			t.Errorf("a: %s = %v, want %v", name, show(c.got), show(c.want))
+		}
+	}
+	if math.Abs(a.Cost-0.03) > 1e-9 {
+		t.Errorf("a: cost = %v, want 0.03", a.Cost)
+	}
+
+	b := summarizeEval("b", results)
+	if b.Cost >= 0 || !equalPtr(b.Semantic, ptr(0.8)) || b.TypeAgreement != nil {
+		t.Errorf("b: cost, semantic, type agreement = %v, %v, %v, want unknown, 0.8, nil", b.Cost, show(b.Semantic), show(b.TypeAgreement))
+	}
+
+	c := summarizeEval("c", results)
+	if c.Samples != 1 || c.Errors != 1 || c.Valid != nil || c.Length != nil || c.Lexical != nil {
+		t.Errorf("c: %+v, want one error and no scores", c)
+	}
+}
+
+// TestRunEvalOffline replays the commits of a temporary repository with the
+// fake and heuristic providers, which must not send anything even when
+// embeddings are configured.
+func TestRunEvalOffline(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s", r.URL.Path)
+		http.Error(w, "offline", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	home, repo := t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, "cache"))
+	t.Setenv("AI_PROVIDER", "openai")
+	t.Setenv("OPENAI_API_KEY", "test")
+	for _, name := range []string{"GIT_AUTHOR", "GIT_COMMITTER"} {
+		t.Setenv(name+"_NAME", "Alice")
+		t.Setenv(name+"_EMAIL", "alice@example.com")
+	}
+	config := `{"openai": {"base_url": "` + server.URL + `/v1"}, "retrieval": {"method": "embeddings"}}`
+	if err := os.WriteFile(filepath.Join(home, ".commitly.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+	git("init", "-q")
+	commits := []struct{ file, message string }{
+		{"invoice.go", "feat(ABC-1): add the invoice export\n\n- Export invoices as CSV"},
+		{"README.md", "docs(ABC-2): document the invoice export\n\n- Describe the CSV columns"},
+		{"invoice.go", "fix(ABC-3): handle invoices without lines\n\n- Skip empty invoices"},
+	}
+	for i, c := range commits {
+		f, err := os.OpenFile(filepath.Join(repo, c.file), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString("line " + string(rune('a'+i)) + "\n")
+		f.Close()
+		git("add", c.file)
+		git("commit", "-q", "-m", c.message)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(repo); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	output := filepath.Join(home, "report.json")
+	if err := runEval([]string{"--models", "fake,heuristic", "--n", "2", "--format", "json", "--output", output}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report evalReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Commits != 2 || len(report.Results) != 4 || len(report.Models) != 2 {
+		t.Fatalf("got %d commits, %d results and %d models, want 2, 4 and 2", report.Commits, len(report.Results), len(report.Models))
+	}
+	fake, heuristic := report.Models[0], report.Models[1]
+	if fake.Model != "fake" || heuristic.Model != "heuristic" {
+		t.Errorf("models = %s, %s, want fake, heuristic", fake.Model, heuristic.Model)
+	}
+	// The fake provider answers with the human message
+	if fake.Errors != 0 || !equalPtr(fake.Valid, ptr(1.0)) || !equalPtr(fake.TypeAgreement, ptr(1.0)) ||
+		!equalPtr(fake.TicketPresence, ptr(1.0)) {
+		t.Errorf("fake = %+v, want no errors and perfect validity, type agreement and ticket presence", fake)
+	}
+	if heuristic.Errors != 0 || heuristic.Valid == nil {
+		t.Errorf("heuristic = %+v, want scores without errors", heuristic)
+	}
+	// Newest first
+	if report.Results[0].Commit == report.Results[2].Commit {
+		t.Errorf("the two commits replayed are the same")
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func show[T any](v *T) any {
+	if v == nil {
+		return "nil"
+	}
+	return *v
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// CommitMessage is the structured form of a commit message. Providers are
+// asked to return it as JSON and it is rendered locally into the final text.
+type CommitMessage struct {
+	Type           string   `json:"type"`
+	Scope          string   `json:"scope"`
+	Subject        string   `json:"subject"`
+	Body           []string `json:"body"`
+	BreakingChange string   `json:"breaking_change"`
+	Footers        []string `json:"footers"`
+}
+
+// Header returns the first line of the message, e.g. "feat(ABC-1)!: add login".
+func (m *CommitMessage) Header() string {
+	if m.Type == "" {
+		return m.Subject
+	}
+	header := m.Type
+	if m.Scope != "" {
+		header += "(" + m.Scope + ")"
+	}
+	if m.BreakingChange != "" {
+		header += "!"
+	}
+	return header + ": " + m.Subject
+}
+
+// commitTypes are the conventional commit types the prompt allows.
+var commitTypes = []string{"feat", "fix", "docs", "style", "refactor", "test", "chore"}
+
+// schema is the subset of JSON Schema that every provider can express.
+type schema struct {
+	Type        string
+	Description string
+	Enum        []string
+	Items       *schema
+	Properties  map[string]*schema
+	Required    []string
+}
+
+// jsonSchema converts the schema to a JSON Schema document. Objects are closed
+// (no additional properties) so OpenAI strict mode accepts them.
+func (s *schema) jsonSchema() map[string]any {
+	out := map[string]any{"type": s.Type}
+	if s.Description != "" {
+		out["description"] = s.Description
+	}
+	if len(s.Enum) > 0 {
+		out["enum"] = s.Enum
+	}
+	if s.Items != nil {
+		out["items"] = s.Items.jsonSchema()
+	}
+	if s.Type == "object" {
+		props := map[string]any{}
+		for name, prop := range s.Properties {
+			props[name] = prop.jsonSchema()
+		}
+		out["properties"] = props
+		out["required"] = s.Required
+		out["additionalProperties"] = false
+	}
+	return out
+}
+
+// geminiSchema converts the schema to the Gemini SDK representation.
+func (s *schema) geminiSchema() *genai.Schema {
+	out := &genai.Schema{
+		Description: s.Description,
+		Enum:        s.Enum,
+		Required:    s.Required,
+	}
+	switch s.Type {
+	case "object":
+		out.Type = genai.TypeObject
+	case "array":
+		out.Type = genai.TypeArray
+	case "boolean":
+		out.Type = genai.TypeBoolean
+	case "integer":
+		out.Type = genai.TypeInteger
+	case "number":
+		out.Type = genai.TypeNumber
+	default:
+		out.Type = genai.TypeString
+	}
+	if s.Items != nil {
+		out.Items = s.Items.geminiSchema()
+	}
+	if len(s.Properties) > 0 {
+		out.Properties = map[string]*genai.Schema{}
+		for name, prop := range s.Properties {
+			out.Properties[name] = prop.geminiSchema()
+		}
+	}
+	return out
+}
+
+// structuredOutput describes a JSON object a provider should return instead
+// of free text. Fallback is appended to the prompt for providers or models
+// that cannot produce structured output, whose reply is then parsed as text.
+type structuredOutput struct {
+	Name        string
+	Description string
+	Schema      *schema
+	Fallback    string
+}
+
+// schemaText returns the JSON schema as a string for inclusion in prompts.
+func (o *structuredOutput) schemaText() string {
+	data, _ := json.Marshal(o.Schema.jsonSchema())
+	return string(data)
+}
+
+// generationRequest is a single request sent to a provider.
+type generationRequest struct {
+	System string
+	Prompt string
+	// Output is nil when free text is expected.
+	Output *structuredOutput
+	// NoCache bypasses the response cache.
+	NoCache bool
+	// Model overrides the configured model of the provider.
+	Model string
+
+	// Redactions and Withheld describe what was removed from the diff, for
+	// the audit log. They are not sent.
+	Redactions []redactionHit
+	Withheld   []string
+
+	// Files and Ticket are used by the local heuristic provider, which reads
+	// the diff instead of the prompt
+	Files  []fileDiff
+	Ticket string
+}
+
+// promptFor returns the user prompt, adding the text format instructions
+// when the provider cannot honor the structured output.
+func (r generationRequest) promptFor(structured bool) string {
+	if r.Output == nil || structured || r.Output.Fallback == "" {
+		return r.Prompt
+	}
+	return r.Prompt + "\n\n" + r.Output.Fallback
+}
+
+// systemFor returns the system prompt sent to provider. Deepseek JSON mode
+// does not take a schema, so it is described in the system prompt instead.
+func (r generationRequest) systemFor(provider Provider, structured bool) string {
+	if provider == ProviderDeepseek && structured {
+		return r.System + "\n\nRespond only with a JSON object that matches this JSON schema:\n" + r.Output.schemaText()
+	}
+	return r.System
+}
+
+// sentRequest is what a provider is sent for a request once the output
+// format is settled.
+type sentRequest struct {
+	System string
+	Prompt string
+	// Schema is the JSON schema of the structured output, empty for text
+	Schema string
+}
+
+// sentTo returns what provider is sent for r when it runs model.
+func (r generationRequest) sentTo(provider Provider, model string) sentRequest {
+	structured := r.Output != nil && supportsStructuredOutput(provider, model)
+	sent := sentRequest{
+		System: r.systemFor(provider, structured),
+		Prompt: r.promptFor(structured),
+	}
+	if structured {
+		sent.Schema = r.Output.schemaText()
+	}
+	return sent
+}
+
+var commitMessageOutput = &structuredOutput{
+	Name:        "commit_message",
+	Description: "A conventional commit message split into its parts.",
+	Schema: &schema{
+		Type: "object",
+		Properties: map[string]*schema{
+			"type":            {Type: "string", Description: "Conventional commit type.", Enum: commitTypes},
+			"scope":           {Type: "string", Description: "Scope of the change, empty if none."},
+			"subject":         {Type: "string", Description: "Concise imperative summary without a trailing period."},
+			"body":            {Type: "array", Description: "Main modifications, one per bullet point.", Items: &schema{Type: "string"}},
+			"breaking_change": {Type: "string", Description: "Description of the breaking change, empty if none."},
+			"footers":         {Type: "array", Description: "Trailer lines such as 'Refs: ABC-123'.", Items: &schema{Type: "string"}},
+		},
+		Required: []string{"type", "scope", "subject", "body", "breaking_change", "footers"},
+	},
+	Fallback: "Respond with the commit message only, in this exact format:\n" +
+		"<type>(<scope>): <subject>\n\n" +
+		"Changes:\n" +
+		"- <first change>\n" +
+		"- <second change>\n\n" +
+		"Add a 'BREAKING CHANGE: <description>' line and other footers at the end only when needed.",
+}
+
+// supportsStructuredOutput reports whether the provider and model can be
+// asked for a schema-constrained JSON object.
+func supportsStructuredOutput(provider Provider, model string) bool {
+	model = strings.ToLower(model)
+	switch provider {
+	case ProviderOpenAI:
+		if model == "gpt-4" || strings.HasPrefix(model, "gpt-4-") || strings.HasPrefix(model, "gpt-3.5") ||
+			strings.HasPrefix(model, "o1-mini") || strings.HasPrefix(model, "o1-preview") {
+			return false
+		}
+		return true
+	case ProviderClaude:
+		return !strings.HasPrefix(model, "claude-2") && !strings.HasPrefix(model, "claude-instant")
+	case ProviderDeepseek:
+		return model != deepseekReasonerModel
+	case ProviderGemini:
+		return !strings.HasPrefix(model, "gemini-1.0") && model != "gemini-pro"
+	case ProviderHeuristic:
+		return true
+	}
+	return false
+}
+
+const deepseekReasonerModel = "deepseek-reasoner"
+
+// parseCommitResponse decodes a provider reply into a CommitMessage. JSON
+// replies are decoded directly, anything else is parsed as text. A JSON reply
+// is never used as the text of the message: it fails when it has no subject.
+func parseCommitResponse(raw string) (*CommitMessage, error) {
+	text := stripCodeFence(raw)
+	if !strings.HasPrefix(text, "{") {
+		return parseCommitText(text), nil
+	}
+	var msg CommitMessage
+	if err := json.Unmarshal([]byte(text), &msg); err == nil && msg.Subject != "" {
+		return &msg, nil
+	}
+
+	// Models without structured output sometimes name or shape the fields
+	// differently, e.g. "description" for the subject or a string body
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(text), &fields); err != nil {
+		return nil, fmt.Errorf("error parsing the reply as JSON: %v", err)
+	}
+	loose := &CommitMessage{
+		Type:           strings.ToLower(jsonString(fields, "type")),
+		Scope:          jsonString(fields, "scope"),
+		Subject:        jsonString(fields, "subject", "description", "title", "summary"),
+		Body:           jsonLines(fields["body"]),
+		BreakingChange: jsonString(fields, "breaking_change", "breaking"),
+		Footers:        jsonLines(fields["footers"]),
+	}
+	if loose.Subject == "" {
+		return nil, fmt.Errorf("the reply has no subject")
+	}
+	return loose, nil
+}
+
+// jsonString returns the first of keys of fields that is a non-empty string.
+func jsonString(fields map[string]any, keys ...string) string {
+	for _, key := range keys {
+		if s, ok := fields[key].(string); ok && strings.TrimSpace(s) != "" {
+			return strings.TrimSpace(s)
+		}
+	}
+	return ""
+}
+
+// jsonLines returns the lines of a JSON string or list of strings, without
+// bullet markers.
+func jsonLines(value any) []string {
+	var items []string
+	switch v := value.(type) {
+	case string:
+		items = strings.Split(v, "\n")
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				items = append(items, s)
+			}
+		}
+	}
+	var lines []string
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		item = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(item, "- "), "* "))
+		if item != "" {
+			lines = append(lines, item)
+		}
+	}
+	return lines
+}
+
+var (
+	headerPattern = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?:\s*(.+)$`)
+	footerPattern = regexp.MustCompile(`^([A-Za-z][A-Za-z-]*|BREAKING CHANGE)(: | #)(.+)$`)
+)
+
+// parseCommitText parses a conventional commit message written as text.
+func parseCommitText(text string) *CommitMessage {
+	msg := &CommitMessage{}
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if len(lines) == 0 {
+		return msg
+	}
+
+	header := strings.TrimSpace(lines[0])
+	if m := headerPattern.FindStringSubmatch(header); m != nil {
+		msg.Type = strings.ToLower(m[1])
+		msg.Scope = m[2]
+		msg.Subject = strings.TrimSpace(m[4])
+		if m[3] == "!" {
+			msg.BreakingChange = msg.Subject
+		}
+	} else {
+		msg.Subject = header
+	}
+
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.EqualFold(line, "Changes:"):
+			continue
+		case strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* "):
+			msg.Body = append(msg.Body, strings.TrimSpace(line[2:]))
+		case strings.HasPrefix(line, "BREAKING CHANGE:") || strings.HasPrefix(line, "BREAKING-CHANGE:"):
+			msg.BreakingChange = strings.TrimSpace(line[len("BREAKING CHANGE:"):])
+		case footerPattern.MatchString(line):
+			msg.Footers = append(msg.Footers, line)
+		default:
+			msg.Body = append(msg.Body, line)
+		}
+	}
+	return msg
+}
+
+// stripCodeFence removes a surrounding markdown code fence, which some
+// models add despite being told not to.
+func stripCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") {
+		return text
+	}
+	text = strings.TrimPrefix(text, "```")
+	if i := strings.Index(text, "\n"); i >= 0 {
+		text = text[i+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "```"))
+}
+
+// renderCommitMessage renders msg using format, a text/template executed
+// with the CommitMessage as data. An empty format uses the default layout.
+func renderCommitMessage(msg *CommitMessage, format string) (string, error) {
+	if format == "" {
+		return defaultCommitFormat(msg), nil
+	}
+	tmpl, err := template.New("commit").Parse(format)
+	if err != nil {
+		return "", fmt.Errorf("error parsing commit format: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, msg); err != nil {
+		return "", fmt.Errorf("error rendering commit format: %v", err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func defaultCommitFormat(msg *CommitMessage) string {
+	var b strings.Builder
+	b.WriteString(msg.Header())
+	if len(msg.Body) > 0 {
+		b.WriteString("\n\nChanges:")
+		for _, item := range msg.Body {
+			b.WriteString("\n- " + item)
+		}
+	}
+	var footers []string
+	if msg.BreakingChange != "" {
+		footers = append(footers, "BREAKING CHANGE: "+msg.BreakingChange)
+	}
+	footers = append(footers, msg.Footers...)
+	if len(footers) > 0 {
+		b.WriteString("\n\n" + strings.Join(footers, "\n"))
+	}
+	return b.String()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCommitResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    *CommitMessage
+		wantErr bool
+	}{
+		{
+			name: "structured",
+			raw:  `{"type":"feat","scope":"ABC-1","subject":"add login","body":["Add the form"],"breaking_change":"","footers":[]}`,
+			want: &CommitMessage{Type: "feat", Scope: "ABC-1", Subject: "add login", Body: []string{"Add the form"}, Footers: []string{}},
+		},
+		{
+			name: "fenced",
+			raw:  "```json\n{\"type\":\"fix\",\"subject\":\"handle nil\"}\n```",
+			want: &CommitMessage{Type: "fix", Subject: "handle nil"},
+		},
+		{
+			name: "description and string body",
+			raw:  `{"type":"Feat","description":"add login","body":"- Add the form\n- Add the route"}`,
+			want: &CommitMessage{Type: "feat", Subject: "add login", Body: []string{"Add the form", "Add the route"}},
+		},
+		{
+			name:    "no subject",
+			raw:     `{"type":"feat","subject":"","body":["Add the form"]}`,
+			wantErr: true,
+		},
+		{
+			name:    "broken JSON",
+			raw:     `{"type":"feat","subject":"add lo`,
+			wantErr: true,
+		},
+		{
+			name: "text",
+			raw:  "fix(ABC-2): handle nil\n\n- Check the user",
+			want: &CommitMessage{Type: "fix", Scope: "ABC-2", Subject: "handle nil", Body: []string{"Check the user"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCommitResponse(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseCommitResponse() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCommitResponse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ProviderHeuristic builds commit messages locally from the diff, without a
+// model. It works offline and needs no API key.
+const ProviderHeuristic Provider = "heuristic"
+
+var (
+	choreFilePattern = regexp.MustCompile(`(^|/)(go\.mod|go\.sum|go\.work|package\.json|package-lock\.json|yarn\.lock|pnpm-lock\.yaml|` +
+		`Cargo\.toml|Cargo\.lock|requirements[^/]*\.txt|pyproject\.toml|poetry\.lock|Pipfile(\.lock)?|Gemfile(\.lock)?|` +
+		`composer\.(json|lock)|Makefile|Dockerfile|\.gitignore|\.gitattributes|\.editorconfig|\.golangci\.ya?ml)$|(^|/)\.github/`)
+	dependencyFilePattern = regexp.MustCompile(`(^|/)(go\.mod|go\.sum|go\.work|package\.json|package-lock\.json|yarn\.lock|pnpm-lock\.yaml|` +
+		`Cargo\.toml|Cargo\.lock|requirements[^/]*\.txt|pyproject\.toml|poetry\.lock|Pipfile(\.lock)?|Gemfile(\.lock)?|composer\.(json|lock))$`)
+	testFilePattern = regexp.MustCompile(`(^|/)(tests?|__tests__|spec|testdata)/|_test\.(go|py)$|\.(test|spec)\.[A-Za-z]+$|(^|/)test_[^/]+\.py$`)
+	docFilePattern  = regexp.MustCompile(`(?i)(^|/)docs?/|\.(md|markdown|rst|adoc|txt)$|(^|/)(LICENSE|NOTICE|AUTHORS|CONTRIBUTORS)[^/]*$`)
+)
+
+// genericDirs are directory names that say nothing about the component.
+var genericDirs = map[string]bool{"src": true, "lib": true, "internal": true, "pkg": true, "cmd": true, "app": true, "source": true}
+
+// changedDeclarations returns the names declared on the added and removed
+// lines of f. Names on both sides were modified.
+func changedDeclarations(f fileDiff) (added, removed, modified []string) {
+	patterns := declarationPatterns[path.Ext(f.Path)]
+	if len(patterns) == 0 {
+		return nil, nil, nil
+	}
+	declared := func(lines []string) map[string]bool {
+		names := map[string]bool{}
+		for _, line := range lines {
+			if _, name, ok := matchDeclaration(patterns, line); ok {
+				names[name] = true
+			}
+		}
+		return names
+	}
+
+	addedLines, removedLines := changedLines(f)
+	plus, minus := declared(addedLines), declared(removedLines)
+	for _, name := range sortedKeys(plus) {
+		if minus[name] {
+			modified = append(modified, name)
+		} else {
+			added = append(added, name)
+		}
+	}
+	for _, name := range sortedKeys(minus) {
+		if !plus[name] {
+			removed = append(removed, name)
+		}
+	}
+	return added, removed, modified
+}
+
+// allMatch reports whether every file path matches re.
+func allMatch(files []fileDiff, re *regexp.Regexp) bool {
+	for _, f := range files {
+		if !re.MatchString(f.Path) {
+			return false
+		}
+	}
+	return len(files) > 0
+}
+
+// inferDirectoryScope returns the last meaningful directory shared by every
+// file, or an empty string when the files have nothing in common.
+func inferDirectoryScope(files []fileDiff) string {
+	var common []string
+	for i, f := range files {
+		dirs := strings.Split(path.Dir(f.Path), "/")
+		if dirs[0] == "." {
+			return ""
+		}
+		if i == 0 {
+			common = dirs
+			continue
+		}
+		n := 0
+		for n < len(common) && n < len(dirs) && common[n] == dirs[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	for i := len(common) - 1; i >= 0; i-- {
+		if !genericDirs[common[i]] {
+			return common[i]
+		}
+	}
+	return ""
+}
+
+// statusVerb is the verb describing how a file changed.
+func statusVerb(f fileDiff) string {
+	switch f.Status {
+	case "added":
+		return "add"
+	case "deleted":
+		return "remove"
+	case "renamed":
+		return "rename"
+	}
+	return "update"
+}
+
+// joinNames lists names as "a", "a and b" or "a, b and 2 more".
+func joinNames(names []string) string {
+	switch {
+	case len(names) == 1:
+		return names[0]
+	case len(names) == 2:
+		return names[0] + " and " + names[1]
+	case len(names) > 2:
+		return fmt.Sprintf("%s, %s and %d more", names[0], names[1], len(names)-2)
+	}
+	return ""
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// isWhitespaceOnly reports whether the added and removed lines of every file
+// only differ in whitespace.
+func isWhitespaceOnly(files []fileDiff) bool {
+	for _, f := range files {
+		if f.Status != "modified" {
+			return false
+		}
+		added, removed := changedLines(f)
+		squash := func(lines []string) string { return strings.Join(strings.Fields(strings.Join(lines, " ")), "") }
+		if squash(added) != squash(removed) {
+			return false
+		}
+	}
+	return len(files) > 0
+}
+
+// heuristicCommitMessage classifies a diff and describes it from the changed
+// files and declarations.
+func heuristicCommitMessage(files []fileDiff, ticket string) *CommitMessage {
+	msg := &CommitMessage{Scope: ticket}
+	if len(files) == 0 {
+		msg.Type, msg.Subject = "chore", "update files"
+		return msg
+	}
+	scope := inferDirectoryScope(files)
+
+	var addedSymbols, removedSymbols []string
+	var newCode bool
+	for _, f := range files {
+		added, removed, modified := changedDeclarations(f)
+		addedSymbols = append(addedSymbols, added...)
+		removedSymbols = append(removedSymbols, removed...)
+		if f.Status == "added" && !testFilePattern.MatchString(f.Path) && !docFilePattern.MatchString(f.Path) {
+			newCode = true
+		}
+
+		bullet := capitalize(statusVerb(f)) + " " + f.Path
+		if f.Status == "renamed" {
+			bullet = "Rename " + f.OldPath + " to " + f.Path
+		}
+		var details []string
+		if len(added) > 0 {
+			details = append(details, "add "+strings.Join(added, ", "))
+		}
+		if len(removed) > 0 {
+			details = append(details, "remove "+strings.Join(removed, ", "))
+		}
+		if len(modified) > 0 {
+			details = append(details, "change "+strings.Join(modified, ", "))
+		}
+		if len(details) > 0 && f.Status == "modified" {
+			bullet += ": " + strings.Join(details, "; ")
+		}
+		msg.Body = append(msg.Body, bullet)
+	}
+	const maxBullets = 10
+	if len(msg.Body) > maxBullets {
+		more := len(msg.Body) - maxBullets + 1
+		msg.Body = append(msg.Body[:maxBullets-1], fmt.Sprintf("Update %d more files", more))
+	}
+
+	single := ""
+	if len(files) == 1 {
+		single = statusVerb(files[0]) + " " + path.Base(files[0].Path)
+	}
+	switch {
+	case allMatch(files, choreFilePattern):
+		msg.Type = "chore"
+		switch {
+		case allMatch(files, dependencyFilePattern):
+			msg.Subject = "update dependencies"
+		case single != "":
+			msg.Subject = single
+		default:
+			msg.Subject = "update build configuration"
+		}
+	case allMatch(files, testFilePattern):
+		msg.Type = "test"
+		if single != "" {
+			msg.Subject = statusVerb(files[0]) + " tests in " + path.Base(files[0].Path)
+		} else {
+			msg.Subject = "update tests"
+		}
+	case allMatch(files, docFilePattern):
+		msg.Type = "docs"
+		if single != "" {
+			msg.Subject = single
+		} else {
+			msg.Subject = "update documentation"
+		}
+	case isWhitespaceOnly(files):
+		msg.Type, msg.Subject = "style", "reformat code"
+	default:
+		sort.Strings(addedSymbols)
+		sort.Strings(removedSymbols)
+		switch {
+		case len(addedSymbols) > 0:
+			msg.Type, msg.Subject = "feat", "add "+joinNames(addedSymbols)
+		case newCode:
+			msg.Type, msg.Subject = "feat", single
+		case len(removedSymbols) > 0:
+			msg.Type, msg.Subject = "refactor", "remove "+joinNames(removedSymbols)
+		default:
+			msg.Type, msg.Subject = "fix", single
+		}
+		if msg.Subject == "" {
+			if scope != "" {
+				msg.Subject = "update " + scope
+			} else {
+				msg.Subject = fmt.Sprintf("update %d files", len(files))
+			}
+		}
+	}
+
+	// A directory named after the type, such as docs/, makes a poor scope.
+	// The ticket moves to a footer when a directory is used instead.
+	if scope != "" && strings.TrimSuffix(scope, "s") != strings.TrimSuffix(msg.Type, "s") {
+		msg.Scope = scope
+		if ticket != "" {
+			msg.Footers = append(msg.Footers, "Refs: "+ticket)
+		}
+	}
+	return msg
+}
+
+// generateHeuristicCommitMessage answers req without a model. The reply is
+// a JSON CommitMessage, like the structured output of the other providers.
+func generateHeuristicCommitMessage(req generationRequest) (*generationResult, error) {
+	data, err := json.Marshal(heuristicCommitMessage(req.Files, req.Ticket))
+	if err != nil {
+		return nil, fmt.Errorf("error serializing commit message: %v", err)
+	}
+	return &generationResult{Text: string(data), Model: defaultModels[ProviderHeuristic]}, nil
+}
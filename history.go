@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultHistoryExamples = 3
+	// historyCandidates is how many recent commits examples are sampled from
+	historyCandidates = 300
+	// exampleMaxFiles and exampleMaxBodyLines keep each example short
+	exampleMaxFiles     = 8
+	exampleMaxBodyLines = 12
+)
+
+// HistoryConfig configures the past commits shown to the model as examples of
+// the style of the repository
+type HistoryConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// Examples is the number of example commits, defaults to 3
+	Examples int `json:"examples,omitempty"`
+	// Branch is the branch examples are taken from, defaults to the HEAD of
+	// origin, main or master
+	Branch string `json:"branch,omitempty"`
+}
+
+func (h HistoryConfig) examples() int {
+	if h.Examples > 0 {
+		return h.Examples
+	}
+	return defaultHistoryExamples
+}
+
+// setHistoryValue sets a key of the history config section.
+func setHistoryValue(history *HistoryConfig, key, value string) error {
+	switch key {
+	case "enabled":
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("history.enabled must be true or false")
+		}
+		history.Disabled = !enabled
+	case "examples":
+		if value == "" {
+			history.Examples = 0
+			return nil
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil || v <= 0 || v > 10 {
+			return fmt.Errorf("history.examples must be an integer between 1 and 10")
+		}
+		history.Examples = v
+	case "branch":
+		history.Branch = value
+	default:
+		return fmt.Errorf("unknown key for history: %s", key)
+	}
+	return nil
+}
+
+// getHistoryValue returns a key of the history config section.
+func getHistoryValue(history HistoryConfig, key string) (string, bool) {
+	switch key {
+	case "enabled":
+		return strconv.FormatBool(!history.Disabled), true
+	case "examples":
+		return strconv.Itoa(history.examples()), true
+	case "branch":
+		return history.Branch, true
+	}
+	return "", false
+}
+
+// botMarkers identify commits made by bots and automation, which do not show
+// how the team writes
+var botMarkers = []string{"[bot]", "dependabot", "renovate", "github-actions", "-bot@", "bot@", "noreply@gitlab"}
+
+// wellFormedCommit reports whether c is a good example: written by a person,
+// neither a merge nor a revert nor a fixup, with a subject of a sensible
+// length and a body.
+func wellFormedCommit(c commitInfo) bool {
+	author := strings.ToLower(c.AuthorName + " " + c.AuthorEmail)
+	for _, marker := range botMarkers {
+		if strings.Contains(author, marker) {
+			return false
+		}
+	}
+	if len(c.Parents) > 1 {
+		return false
+	}
+	subject := strings.TrimSpace(c.Subject())
+	lower := strings.ToLower(subject)
+	for _, prefix := range []string{"revert", "merge ", "fixup!", "squash!", "amend!", "wip"} {
+		if strings.HasPrefix(lower, prefix) {
+			return false
+		}
+	}
+	if len(subject) < 10 || len(subject) > 72 {
+		return false
+	}
+	_, body, _ := strings.Cut(c.Message, "\n")
+	return strings.TrimSpace(body) != ""
+}
+
+// sampleHistory ranks the well-formed commits of the main branch reachable
+// from rev as examples: newest first, taking one of each commit type in turn
+// so the first examples show the range of changes the team makes.
+// Conventional commits come before the others.
+func sampleHistory(branch, rev string) ([]commitInfo, error) {
+	start := rev
+	if _, err := runGit("", "rev-parse", "--verify", "-q", branch); err == nil {
+		// Commits of the current branch are not examples of the team's style
+		if base, err := runGit("", "merge-base", branch, rev); err == nil {
+			start = strings.TrimSpace(base)
+		}
+	}
+	output, err := runGit("", "log", "--no-merges", "-n", strconv.Itoa(historyCandidates),
+		"--format=%H%x00%P%x00%an%x00%ae%x00%B%x1e", start)
+	if err != nil {
+		return nil, err
+	}
+
+	byType := map[string][]commitInfo{}
+	var types []string
+	for _, record := range strings.Split(output, "\x1e") {
+		fields := strings.SplitN(strings.TrimLeft(record, "\n"), "\x00", 5)
+		if len(fields) != 5 {
+			continue
+		}
+		c := commitInfo{SHA: fields[0], Parents: strings.Fields(fields[1]), AuthorName: fields[2], AuthorEmail: fields[3],
+			Message: strings.TrimSpace(fields[4])}
+		if !wellFormedCommit(c) {
+			continue
+		}
+		commitType := parseCommitText(c.Message).Type
+		if _, ok := byType[commitType]; !ok {
+			types = append(types, commitType)
+		}
+		byType[commitType] = append(byType[commitType], c)
+	}
+	// Non-conventional commits last, then the most frequent types first
+	sort.SliceStable(types, func(i, j int) bool {
+		if (types[i] == "") != (types[j] == "") {
+			return types[j] == ""
+		}
+		return len(byType[types[i]]) > len(byType[types[j]])
+	})
+
+	var sample []commitInfo
+	for round := 0; ; round++ {
+		added := false
+		for _, t := range types {
+			if round < len(byType[t]) {
+				sample = append(sample, byType[t][round])
+				added = true
+			}
+		}
+		if !added {
+			return sample, nil
+		}
+	}
+}
+
+// changeSummary lists the files changed by commit c with their line counts,
+// leaving out the files withheld by the privacy policy.
+func changeSummary(c commitInfo, privacy PrivacyConfig) (string, error) {
+	output, err := runGit("", "show", "--format=", "--numstat", "--summary", "--no-renames", c.SHA)
+	if err != nil {
+		return "", err
+	}
+	withheld, err := withheldPaths(privacy, numstatPaths(output))
+	if err != nil {
+		return "", err
+	}
+	return summarizeNumstat(output, withheld), nil
+}
+
+// numstatPaths returns the paths of the --numstat output of git log.
+func numstatPaths(output string) []string {
+	var paths []string
+	for _, line := range strings.Split(output, "\n") {
+		if fields := strings.SplitN(line, "\t", 3); len(fields) == 3 {
+			paths = append(paths, fields[2])
+		}
+	}
+	return paths
+}
+
+// summarizeNumstat turns the --numstat --summary output of git log into the
+// lines of summarizeFiles, leaving out the withheld paths.
+func summarizeNumstat(output string, withheld map[string]bool) string {
+	var files []fileDiff
+	added := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		if fields := strings.SplitN(line, "\t", 3); len(fields) == 3 {
+			f := fileDiff{Path: fields[2], Status: "modified", Binary: fields[0] == "-"}
+			f.Added, _ = strconv.Atoi(fields[0])
+			f.Removed, _ = strconv.Atoi(fields[1])
+			files = append(files, f)
+		} else if fields := strings.Fields(line); len(fields) == 4 && fields[0] == "create" && fields[1] == "mode" {
+			added[fields[3]] = true
+		}
+	}
+	var public []fileDiff
+	for _, f := range files {
+		if added[f.Path] {
+			f.Status = "added"
+		}
+		if !withheld[f.Path] {
+			public = append(public, f)
+		}
+	}
+	return summarizeFiles(public, len(files)-len(public))
+}
+
+// summarizeFiles lists files with their line counts, e.g.
+// "- db/migrations/003_users.sql (added, +12 -0)", followed by the number of
+// files left out.
+func summarizeFiles(files []fileDiff, hidden int) string {
+	var lines []string
+	for _, f := range files {
+		if len(lines) == exampleMaxFiles {
+			hidden++
+			continue
+		}
+		counts := fmt.Sprintf("+%d -%d", f.Added, f.Removed)
+		if f.Binary {
+			counts = "binary"
+		}
+		if f.Status == "added" {
+			counts = "added, " + counts
+		}
+		lines = append(lines, fmt.Sprintf("- %s (%s)", f.Path, counts))
+	}
+	if hidden > 0 {
+		lines = append(lines, fmt.Sprintf("- %d more files", hidden))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// historyExamples returns past commits of the repository as pairs of changed
+// files and message, for the model to follow their style. rev is the commit
+// the generated one follows, "" for HEAD. Examples in which secrets were
+// found are skipped rather than redacted.
+func historyExamples(cfg *Config, rev string) (string, error) {
+	if cfg.History.Disabled {
+		return "", nil
+	}
+	if rev == "" {
+		rev = "HEAD"
+	}
+	if _, err := runGit("", "rev-parse", "--verify", "-q", rev); err != nil {
+		// No commits yet
+		return "", nil
+	}
+	branch := cfg.History.Branch
+	if branch == "" {
+		branch = defaultBase()
+	}
+	commits, err := sampleHistory(branch, rev)
+	if err != nil {
+		return "", err
+	}
+
+	var examples []string
+	for _, c := range commits {
+		if len(examples) == cfg.History.examples() {
+			break
+		}
+		summary, err := changeSummary(c, cfg.Privacy)
+		if err != nil {
+			return "", err
+		}
+		lines := strings.Split(c.Message, "\n")
+		if len(lines) > exampleMaxBodyLines+2 {
+			lines = append(lines[:exampleMaxBodyLines+2], "...")
+		}
+		example := fmt.Sprintf("Changed files:\n%s\nMessage:\n%s", summary, strings.Join(lines, "\n"))
+		if _, hits, err := redactText(example, "history", cfg.Redaction); err != nil {
+			return "", err
+		} else if len(hits) > 0 {
+			continue
+		}
+		examples = append(examples, fmt.Sprintf("Example %d\n%s", len(examples)+1, example))
+	}
+	return strings.Join(examples, "\n\n"), nil
+}
+
+// promptHistory returns the part of a prompt that shows past commits: examples
+// of the style of the team and the commits most similar to the changed files.
+// rev is the commit the generated one follows, "" for HEAD.
+func promptHistory(cfg *Config, files []fileDiff, rev string) (string, error) {
+	examples, err := historyExamples(cfg, rev)
+	if err != nil {
+		return "", err
+	}
+	similar, err := similarCommits(cfg, files, rev)
+	if err != nil {
+		return "", err
+	}
+	text := ""
+	if examples != "" {
+		text += "\n\nThese past commits of the repository show the style to follow. Match their wording, " +
+			"level of detail and body layout, but describe only the changes above:\n\n" + examples
+	}
+	if similar != "" {
+		text += "\n\nThese past commits made changes similar to this one. Describe a change of the same kind " +
+			"the same way:\n\n" + similar
+	}
+	return text, nil
+}
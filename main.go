@@ -13,6 +13,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/cohesion-org/deepseek-go"
 	"github.com/google/generative-ai-go/genai"
@@ -36,6 +37,34 @@ type ProviderConfig struct {
 	Provider string `json:"provider"`
 	APIKey   string `json:"api_key"`
 	Model    string `json:"model"`
+	// BaseURL points the provider SDK at another endpoint, such as a local
+	// OpenAI compatible server
+	BaseURL string `json:"base_url,omitempty"`
+
+	// Generation parameters, left to the provider default when unset
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"top_p,omitempty"`
+	MaxTokens       *int     `json:"max_tokens,omitempty"`
+	Seed            *int64   `json:"seed,omitempty"`
+	Stop            []string `json:"stop,omitempty"`
+	ReasoningEffort string   `json:"reasoning_effort,omitempty"`
+
+	// Request limits enforced locally before a request is sent
+	MaxRequestsPerMinute int `json:"max_requests_per_minute,omitempty"`
+	// BudgetModel is a cheaper model used once a spend cap is reached
+	BudgetModel string `json:"budget_model,omitempty"`
+
+	// SafetySettings maps a Gemini harm category to a block threshold
+	SafetySettings map[string]string `json:"safety_settings,omitempty"`
+}
+
+// CommitConfig holds settings for rendering commit messages
+type CommitConfig struct {
+	// Format is a text/template rendered with the CommitMessage; empty uses the default layout
+	Format string `json:"format,omitempty"`
+	// TicketKeys are the Jira project keys, such as ABC, of the tickets to
+	// look for in commit messages; empty accepts any key
+	TicketKeys []string `json:"ticket_keys,omitempty"`
 }
 
 // Config holds application configuration
@@ -45,8 +74,42 @@ type Config struct {
 	Deepseek        ProviderConfig `json:"deepseek"`
 	Gemini          ProviderConfig `json:"gemini"`
 	DefaultProvider string         `json:"default_provider"`
+	Commit          CommitConfig   `json:"commit"`
+	// Prices overrides the built-in price table, keyed by model name
+	Prices map[string]ModelPrice `json:"prices,omitempty"`
+	Budget BudgetConfig          `json:"budget"`
+	Cache  CacheConfig           `json:"cache"`
+
+	Redaction RedactionConfig `json:"redaction"`
+	Privacy   PrivacyConfig   `json:"privacy"`
+	Diff      DiffConfig      `json:"diff"`
+	Audit     AuditConfig     `json:"audit"`
+	Scope     ScopeConfig     `json:"scope"`
+	PR        PRConfig        `json:"pr"`
+	History   HistoryConfig   `json:"history"`
+	Retrieval RetrievalConfig `json:"retrieval"`
+
+	// Policy is the organization policy applied over the user config, nil
+	// when there is none
+	Policy *Policy `json:"-"`
+}
+
+// defaultModels are the models used when a provider has none configured
+var defaultModels = map[Provider]string{
+	ProviderOpenAI:    "gpt-4o",
+	ProviderClaude:    string(anthropic.ModelClaude3Dot5SonnetLatest),
+	ProviderDeepseek:  deepseek.DeepSeekChat,
+	ProviderGemini:    "gemini-1.5-flash-latest",
+	ProviderHeuristic: "heuristic",
 }
 
+const systemPrompt = "You are a commit message generator that creates messages in the conventional commit format. " +
+	"You always follow the format: <type>(<ticket>): <title>\n<optional body>. " +
+	"Types are limited to: feat, fix, docs, style, refactor, test, chore. " +
+	"Keep the title concise and descriptive. Add body only if additional context is needed. " +
+	"Changes that break users of the code, such as removed or changed public APIs, flags or database columns, " +
+	"must be described in breaking_change, which adds the ! marker and the BREAKING CHANGE footer."
+
 func main() {
 	// Define command-line flags
 	configCmd := flag.NewFlagSet("config", flag.ExitOnError)
@@ -107,9 +170,70 @@ func main() {
 			fmt.Println("Usage: commitly config <command>")
 			fmt.Println("Available commands: set, get, show")
 			return
+		case "usage":
+			if err := runUsage(os.Args[2:]); err != nil {
+				log.Fatalf("Error reading usage: %v", err)
+			}
+			return
+		case "cache":
+			if err := runCache(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		case "audit":
+			if err := runAudit(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		case "split":
+			if err := runSplit(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		case "reword":
+			if err := runReword(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		case "squash":
+			if err := runSquash(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		case "pr":
+			if err := runPR(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		case "changelog":
+			if err := runChangelog(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		case "release":
+			if err := runRelease(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		case "dataset":
+			if err := runDataset(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		case "eval":
+			if err := runEval(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
 		}
 	}
 
+	// Flags for the normal execution flow
+	rootCmd := flag.NewFlagSet("commitly", flag.ExitOnError)
+	noCache := rootCmd.Bool("no-cache", false, "always call the provider instead of reusing a cached response")
+	dryRun := rootCmd.Bool("dry-run", false, "print the prompts and estimated tokens without calling a provider")
+	rootCmd.Parse(os.Args[1:])
+
 	// Normal execution flow for generating commit message
 	// Ask user for the Jira ticket name
 	reader := bufio.NewReader(os.Stdin)
@@ -120,36 +244,38 @@ func main() {
 	}
 	ticket = strings.TrimSpace(ticket)
 
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+
 	// Get git diff of changes
-	gitDiff, err := getGitDiff()
+	gitDiff, source, err := getGitDiff()
 	if err != nil {
 		log.Fatalf("Error getting git diff: %v", err)
 	}
 
-	// Get history of last 10 commits
-	commitHistory, err := getCommitHistory()
+	// Withhold private files and mask secrets before the diff leaves the machine
+	diff, err := prepareDiff(cfg, gitDiff, source)
 	if err != nil {
-		log.Fatalf("Error getting commit history: %v", err)
+		log.Fatalf("Error preparing git diff: %v", err)
+	}
+	if err := checkPreparedDiff(cfg, diff, !*dryRun); err != nil {
+		log.Fatalf("Error preparing git diff: %v", err)
 	}
 
-	// Create the prompt
-	prompt := fmt.Sprintf(
-		"Generate a commit message for Jira ticket '%s' following this exact format:\n"+
-			"<type>(%s): <title>\n\n"+
-			"Changes:\n"+
-			"- <first change>\n"+
-			"- <second change>\n"+
-			"- <additional changes if needed>\n\n"+
-			"Where:\n"+
-			"- <type> should be one of: feat, fix, docs, style, refactor, test, chore\n"+
-			"- (%s) is the Jira ticket number\n"+
-			"- <title> is a concise description\n"+
-			"- Changes section should list the main modifications as bullet points\n\n"+
-			"The diff of changes is:\n%s\n\n"+
-			"The history of previous commit messages is:\n%s\n\n"+
-			"Provide a commit message that follows this format strictly, with bullet points for changes.",
-		ticket, ticket, ticket, gitDiff, commitHistory,
-	)
+	// Name the scope after the component that changed the most
+	component := resolveComponent(cfg, diff)
+
+	// Past commits show the model how the team writes. A dry run ranks
+	// similar commits locally, as it sends nothing
+	if *dryRun {
+		cfg.Retrieval.Method = "bm25"
+	}
+	commitHistory, err := promptHistory(cfg, diff.Files, "")
+	if err != nil {
+		log.Fatalf("Error getting commit history: %v", err)
+	}
 
 	// Get provider from environment variable or config
 	provider, err := getProvider()
@@ -157,14 +283,129 @@ func main() {
 		log.Fatalf("Error determining provider: %v", err)
 	}
 
+	req := commitRequest(diff, ticket, component, commitHistory)
+	req.NoCache = *noCache
+	if *dryRun {
+		printDryRun(cfg, req, provider, diff)
+		return
+	}
+
 	// Generate commit message using selected provider
-	commitMessage, err := generateCommitMessage(prompt, provider)
+	result, err := generateCommitMessage(req, provider)
 	if err != nil {
 		log.Fatalf("Error generating commit message: %v", err)
 	}
 
+	// Render the structured message through the configured format
+	_, commitMessage, err := finishCommitMessage(cfg, result, diff, ticket, component)
+	if err != nil {
+		log.Fatalf("Error rendering commit message: %v", err)
+	}
+
 	fmt.Println("\nGenerated commit message:")
 	fmt.Println(commitMessage)
+	fmt.Println()
+	printUsageSummary(result)
+}
+
+// checkPreparedDiff reports the files withheld and the secrets redacted from
+// diff. It returns an error when strict redaction forbids sending it.
+func checkPreparedDiff(cfg *Config, diff *preparedDiff, strict bool) error {
+	for _, f := range diff.Withheld {
+		fmt.Fprintf(os.Stderr, "Withheld by privacy policy: %s\n", f.Summary())
+	}
+	if hits := diff.Redactions; len(hits) > 0 {
+		if cfg.Redaction.Strict && strict {
+			return fmt.Errorf("refusing to send the diff, found %d potential secrets: %s\n"+
+				"Unstage them or disable strict mode with: commitly config set redaction.strict false",
+				len(hits), describeHits(hits))
+		}
+		fmt.Fprintf(os.Stderr, "Warning: redacted %d potential secrets before sending the diff: %s\n",
+			len(hits), describeHits(hits))
+	}
+	return nil
+}
+
+// resolveComponent returns the component scope of diff, warning when the
+// changes span several components.
+func resolveComponent(cfg *Config, diff *preparedDiff) string {
+	component, components := resolveScope(cfg.Scope, diff.Files)
+	if len(components) > 1 {
+		fmt.Fprintf(os.Stderr, "Warning: the changes span %d components (%s), consider committing them separately\n",
+			len(components), strings.Join(components, ", "))
+	}
+	return component
+}
+
+// commitRequest builds the request for the commit message of diff. component
+// is the scope resolved from the changed paths, if any, and history is the
+// section of promptHistory.
+func commitRequest(diff *preparedDiff, ticket, component, history string) generationRequest {
+	scopeLine := fmt.Sprintf("- scope: the Jira ticket number (%s)\n", ticket)
+	footersLine := "- footers: additional trailer lines, or empty\n"
+	if component != "" {
+		scopeLine = fmt.Sprintf("- scope: the component that changed (%s)\n", component)
+		footersLine = fmt.Sprintf("- footers: additional trailer lines, including 'Refs: %s'\n", ticket)
+	}
+
+	// Create the prompt
+	prompt := fmt.Sprintf(
+		"Generate a commit message for Jira ticket '%s' with these parts:\n"+
+			"- type: one of feat, fix, docs, style, refactor, test, chore\n"+
+			"%s"+
+			"- subject: a concise description\n"+
+			"- body: the main modifications as bullet points\n"+
+			"- breaking_change: a description of any breaking change, or empty\n"+
+			"%s\n"+
+			"%s"+
+			"The diff of changes is:\n%s"+
+			"%s",
+		ticket, scopeLine, footersLine, diffContext(diff), diff.Text, history,
+	)
+
+	var withheld []string
+	for _, f := range diff.Withheld {
+		withheld = append(withheld, f.Summary())
+	}
+	return generationRequest{
+		System:     systemPrompt,
+		Prompt:     prompt,
+		Output:     commitMessageOutput,
+		Redactions: diff.Redactions,
+		Withheld:   withheld,
+		Files:      diff.Files,
+		Ticket:     ticket,
+	}
+}
+
+// diffContext describes the changed declarations and breaking changes of
+// diff, which go ahead of the raw diff in prompts.
+func diffContext(diff *preparedDiff) string {
+	text := ""
+	if diff.SymbolSummary != "" {
+		text = "The changed functions, methods and types are:\n" + diff.SymbolSummary + "\n"
+	}
+	if len(diff.Breaking) > 0 {
+		text += "These likely breaking changes were detected, describe the real ones in breaking_change:\n" +
+			describeBreakingChanges(diff.Breaking) + "\n"
+	}
+	return text
+}
+
+// finishCommitMessage parses the reply to a commit request, makes sure it
+// carries the component scope, and the detected breaking changes when the
+// heuristic provider wrote it, and renders it through the configured format.
+func finishCommitMessage(cfg *Config, result *generationResult, diff *preparedDiff, ticket, component string) (*CommitMessage, string, error) {
+	msg, err := parseCommitResponse(result.Text)
+	if err != nil {
+		return nil, "", err
+	}
+	if result.Provider == ProviderHeuristic {
+		applyBreakingChanges(msg, diff.Breaking)
+	}
+	applyScope(msg, component, ticket)
+	text, err := renderCommitMessage(msg, cfg.Commit.Format)
+	return msg, text, err
 }
 
 func getProvider() (Provider, error) {
@@ -192,32 +433,63 @@ func getConfigPath() string {
 	return filepath.Join(homeDir, ".commitly.json")
 }
 
+// getDataDir returns the directory holding commitly's local state, such as
+// the usage log.
+func getDataDir() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ".commitly"
+	}
+	return filepath.Join(homeDir, ".commitly")
+}
+
+// defaultConfig returns the configuration used when there is no config file.
+func defaultConfig() *Config {
+	return &Config{
+		DefaultProvider: string(ProviderOpenAI),
+		OpenAI: ProviderConfig{
+			Provider: string(ProviderOpenAI),
+			Model:    "gpt-4o",
+		},
+		Claude: ProviderConfig{
+			Provider: string(ProviderClaude),
+			Model:    "claude-3-5-sonnet-20241022",
+		},
+		Deepseek: ProviderConfig{
+			Provider: string(ProviderDeepseek),
+			Model:    "deepseek-chat",
+		},
+		Gemini: ProviderConfig{
+			Provider: string(ProviderGemini),
+			Model:    "gemini-1.5-flash-latest",
+		},
+	}
+}
+
+// loadConfig returns the user config with the organization policy applied.
 func loadConfig() (*Config, error) {
+	cfg, err := loadUserConfig()
+	if err != nil {
+		return nil, err
+	}
+	policy, err := loadPolicy()
+	if err != nil {
+		return nil, err
+	}
+	if err := applyPolicy(cfg, policy); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadUserConfig reads the user config file as written by "commitly config set".
+func loadUserConfig() (*Config, error) {
 	configPath := getConfigPath()
 	
 	// Check if config file exists
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
 		// Create default config
-		cfg := &Config{
-			DefaultProvider: string(ProviderOpenAI),
-			OpenAI: ProviderConfig{
-				Provider: string(ProviderOpenAI),
-				Model:    "gpt-4o",
-			},
-			Claude: ProviderConfig{
-				Provider: string(ProviderClaude),
-				Model:    "claude-3-5-sonnet-20241022",
-			},
-			Deepseek: ProviderConfig{
-				Provider: string(ProviderDeepseek),
-				Model:    "deepseek-chat",
-			},
-			Gemini: ProviderConfig{
-				Provider: string(ProviderGemini),
-				Model:    "gemini-1.5-flash-latest",
-			},
-		}
-		return cfg, nil
+		return defaultConfig(), nil
 	}
 
 	// Read config file
@@ -253,31 +525,32 @@ func saveConfig(cfg *Config) error {
 }
 
 func setConfig(key, value string) error {
-	cfg, err := loadConfig()
+	// Keys locked by the organization policy cannot be changed
+	policy, err := loadPolicy()
 	if err != nil {
-		cfg = &Config{
-			DefaultProvider: string(ProviderOpenAI),
-			OpenAI: ProviderConfig{
-				Provider: string(ProviderOpenAI),
-				Model:    "gpt-4o",
-			},
-			Claude: ProviderConfig{
-				Provider: string(ProviderClaude),
-				Model:    "claude-3-5-sonnet-20241022",
-			},
-			Deepseek: ProviderConfig{
-				Provider: string(ProviderDeepseek),
-				Model:    "deepseek-chat",
-			},
-			Gemini: ProviderConfig{
-				Provider: string(ProviderGemini),
-				Model:    "gemini-1.5-flash-latest",
-			},
-		}
+		return err
+	}
+	if policy.locks(key) {
+		return fmt.Errorf("%s is locked by the policy in %s", key, policy.path)
+	}
+
+	// The policy is applied when loading, so it is never written back
+	cfg, err := loadUserConfig()
+	if err != nil {
+		cfg = defaultConfig()
 	}
 
-	// Update config based on key
-	parts := strings.Split(key, ".")
+	if err := applyConfigValue(cfg, key, value); err != nil {
+		return err
+	}
+
+	// Save updated config
+	return saveConfig(cfg)
+}
+
+// applyConfigValue sets a "section.key" value on cfg.
+func applyConfigValue(cfg *Config, key, value string) error {
+	parts := strings.SplitN(key, ".", 2)
 	if len(parts) != 2 {
 		return fmt.Errorf("invalid config key format, expected 'section.key'")
 	}
@@ -293,7 +566,13 @@ func setConfig(key, value string) error {
 		case "model":
 			cfg.OpenAI.Model = value
 		default:
-			return fmt.Errorf("unknown key for openai: %s", key)
+			ok, err := setProviderValue(&cfg.OpenAI, key, value)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("unknown key for openai: %s", key)
+			}
 		}
 	case "claude":
 		switch key {
@@ -304,7 +583,13 @@ func setConfig(key, value string) error {
 		case "model":
 			cfg.Claude.Model = value
 		default:
-			return fmt.Errorf("unknown key for claude: %s", key)
+			ok, err := setProviderValue(&cfg.Claude, key, value)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("unknown key for claude: %s", key)
+			}
 		}
 	case "deepseek":
 		switch key {
@@ -315,7 +600,13 @@ func setConfig(key, value string) error {
 		case "model":
 			cfg.Deepseek.Model = value
 		default:
-			return fmt.Errorf("unknown key for deepseek: %s", key)
+			ok, err := setProviderValue(&cfg.Deepseek, key, value)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("unknown key for deepseek: %s", key)
+			}
 		}
 	case "gemini":
 		switch key {
@@ -325,8 +616,20 @@ func setConfig(key, value string) error {
 			cfg.Gemini.Provider = value
 		case "model":
 			cfg.Gemini.Model = value
+		case "safety_settings":
+			settings, err := parseSafetySettings(value)
+			if err != nil {
+				return err
+			}
+			cfg.Gemini.SafetySettings = settings
 		default:
-			return fmt.Errorf("unknown key for gemini: %s", key)
+			ok, err := setProviderValue(&cfg.Gemini, key, value)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("unknown key for gemini: %s", key)
+			}
 		}
 	case "default":
 		if key == "provider" {
@@ -334,12 +637,77 @@ func setConfig(key, value string) error {
 		} else {
 			return fmt.Errorf("unknown key for default: %s", key)
 		}
+	case "commit":
+		switch key {
+		case "format":
+			cfg.Commit.Format = value
+		case "ticket_keys":
+			cfg.Commit.TicketKeys = splitList(strings.ToUpper(value))
+		default:
+			return fmt.Errorf("unknown key for commit: %s", key)
+		}
+	case "budget":
+		if err := setBudgetValue(&cfg.Budget, key, value); err != nil {
+			return err
+		}
+	case "cache":
+		if err := setCacheValue(&cfg.Cache, key, value); err != nil {
+			return err
+		}
+	case "redaction":
+		if err := setRedactionValue(&cfg.Redaction, key, value); err != nil {
+			return err
+		}
+	case "audit":
+		if err := setAuditValue(&cfg.Audit, key, value); err != nil {
+			return err
+		}
+	case "diff":
+		if err := setDiffValue(&cfg.Diff, key, value); err != nil {
+			return err
+		}
+	case "scope":
+		if err := setScopeValue(&cfg.Scope, key, value); err != nil {
+			return err
+		}
+	case "pr":
+		if err := setPRValue(&cfg.PR, key, value); err != nil {
+			return err
+		}
+	case "history":
+		if err := setHistoryValue(&cfg.History, key, value); err != nil {
+			return err
+		}
+	case "retrieval":
+		if err := setRetrievalValue(&cfg.Retrieval, key, value); err != nil {
+			return err
+		}
+	case "privacy":
+		switch key {
+		case "include":
+			cfg.Privacy.Include = splitList(value)
+		case "exclude":
+			cfg.Privacy.Exclude = splitList(value)
+		default:
+			return fmt.Errorf("unknown key for privacy: %s", key)
+		}
+	case "prices":
+		if value == "" {
+			delete(cfg.Prices, key)
+			break
+		}
+		price, err := parsePrice(value)
+		if err != nil {
+			return err
+		}
+		if cfg.Prices == nil {
+			cfg.Prices = map[string]ModelPrice{}
+		}
+		cfg.Prices[key] = price
 	default:
 		return fmt.Errorf("unknown config section: %s", section)
 	}
-
-	// Save updated config
-	return saveConfig(cfg)
+	return nil
 }
 
 func getConfigValue(key string) (string, error) {
@@ -347,9 +715,13 @@ func getConfigValue(key string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	return configValue(cfg, key)
+}
 
+// configValue returns a "section.key" value of cfg.
+func configValue(cfg *Config, key string) (string, error) {
 	// Get config value based on key
-	parts := strings.Split(key, ".")
+	parts := strings.SplitN(key, ".", 2)
 	if len(parts) != 2 {
 		return "", fmt.Errorf("invalid config key format, expected 'section.key'")
 	}
@@ -364,6 +736,10 @@ func getConfigValue(key string) (string, error) {
 			return cfg.OpenAI.Provider, nil
 		case "model":
 			return cfg.OpenAI.Model, nil
+		default:
+			if value, ok := getProviderValue(cfg.OpenAI, key); ok {
+				return value, nil
+			}
 		}
 	case "claude":
 		switch key {
@@ -373,6 +749,10 @@ func getConfigValue(key string) (string, error) {
 			return cfg.Claude.Provider, nil
 		case "model":
 			return cfg.Claude.Model, nil
+		default:
+			if value, ok := getProviderValue(cfg.Claude, key); ok {
+				return value, nil
+			}
 		}
 	case "deepseek":
 		switch key {
@@ -382,6 +762,10 @@ func getConfigValue(key string) (string, error) {
 			return cfg.Deepseek.Provider, nil
 		case "model":
 			return cfg.Deepseek.Model, nil
+		default:
+			if value, ok := getProviderValue(cfg.Deepseek, key); ok {
+				return value, nil
+			}
 		}
 	case "gemini":
 		switch key {
@@ -391,40 +775,228 @@ func getConfigValue(key string) (string, error) {
 			return cfg.Gemini.Provider, nil
 		case "model":
 			return cfg.Gemini.Model, nil
+		case "safety_settings":
+			return formatSafetySettings(cfg.Gemini.SafetySettings), nil
+		default:
+			if value, ok := getProviderValue(cfg.Gemini, key); ok {
+				return value, nil
+			}
 		}
 	case "default":
 		if key == "provider" {
 			return cfg.DefaultProvider, nil
 		}
+	case "commit":
+		switch key {
+		case "format":
+			return cfg.Commit.Format, nil
+		case "ticket_keys":
+			return strings.Join(cfg.Commit.TicketKeys, ","), nil
+		}
+	case "budget":
+		if value, ok := getBudgetValue(cfg.Budget, key); ok {
+			return value, nil
+		}
+	case "cache":
+		if value, ok := getCacheValue(cfg.Cache, key); ok {
+			return value, nil
+		}
+	case "redaction":
+		if value, ok := getRedactionValue(cfg.Redaction, key); ok {
+			return value, nil
+		}
+	case "audit":
+		if value, ok := getAuditValue(cfg.Audit, key); ok {
+			return value, nil
+		}
+	case "diff":
+		if value, ok := getDiffValue(cfg.Diff, key); ok {
+			return value, nil
+		}
+	case "scope":
+		if value, ok := getScopeValue(cfg.Scope, key); ok {
+			return value, nil
+		}
+	case "pr":
+		if value, ok := getPRValue(cfg.PR, key); ok {
+			return value, nil
+		}
+	case "history":
+		if value, ok := getHistoryValue(cfg.History, key); ok {
+			return value, nil
+		}
+	case "retrieval":
+		if value, ok := getRetrievalValue(cfg.Retrieval, key); ok {
+			return value, nil
+		}
+	case "privacy":
+		switch key {
+		case "include":
+			return strings.Join(cfg.Privacy.Include, ","), nil
+		case "exclude":
+			return strings.Join(cfg.Privacy.Exclude, ","), nil
+		}
+	case "prices":
+		if price, ok := lookupPrice(cfg, key); ok {
+			return fmt.Sprintf("%g,%g", price.Input, price.Output), nil
+		}
+		return "", nil
 	}
 
 	return "", fmt.Errorf("unknown config key: %s.%s", section, key)
 }
 
 func printConfig(cfg *Config) {
+	lock := cfg.Policy.lockMark
+
 	fmt.Println("Current configuration:")
 	fmt.Println("---------------------")
-	fmt.Printf("Default Provider: %s\n\n", cfg.DefaultProvider)
+	if cfg.Policy != nil {
+		fmt.Printf("Organization policy: %s\n", cfg.Policy.path)
+		printPolicy(cfg.Policy)
+		fmt.Println()
+	}
+	fmt.Printf("Default Provider: %s%s\n\n", cfg.DefaultProvider, lock("default.provider"))
 	
 	fmt.Println("OpenAI Configuration:")
-	fmt.Printf("  Provider: %s\n", cfg.OpenAI.Provider)
-	fmt.Printf("  Model: %s\n", cfg.OpenAI.Model)
-	fmt.Printf("  API Key: %s\n\n", maskAPIKey(cfg.OpenAI.APIKey))
+	fmt.Printf("  Provider: %s%s\n", cfg.OpenAI.Provider, lock("openai.provider"))
+	fmt.Printf("  Model: %s%s\n", cfg.OpenAI.Model, lock("openai.model"))
+	fmt.Printf("  API Key: %s%s\n", maskAPIKey(cfg.OpenAI.APIKey), lock("openai.api_key"))
+	printProviderOptions("openai", cfg.OpenAI, cfg.Policy)
+	fmt.Println()
 	
 	fmt.Println("Claude Configuration:")
-	fmt.Printf("  Provider: %s\n", cfg.Claude.Provider)
-	fmt.Printf("  Model: %s\n", cfg.Claude.Model)
-	fmt.Printf("  API Key: %s\n\n", maskAPIKey(cfg.Claude.APIKey))
+	fmt.Printf("  Provider: %s%s\n", cfg.Claude.Provider, lock("claude.provider"))
+	fmt.Printf("  Model: %s%s\n", cfg.Claude.Model, lock("claude.model"))
+	fmt.Printf("  API Key: %s%s\n", maskAPIKey(cfg.Claude.APIKey), lock("claude.api_key"))
+	printProviderOptions("claude", cfg.Claude, cfg.Policy)
+	fmt.Println()
 	
 	fmt.Println("Deepseek Configuration:")
-	fmt.Printf("  Provider: %s\n", cfg.Deepseek.Provider)
-	fmt.Printf("  Model: %s\n", cfg.Deepseek.Model)
-	fmt.Printf("  API Key: %s\n\n", maskAPIKey(cfg.Deepseek.APIKey))
+	fmt.Printf("  Provider: %s%s\n", cfg.Deepseek.Provider, lock("deepseek.provider"))
+	fmt.Printf("  Model: %s%s\n", cfg.Deepseek.Model, lock("deepseek.model"))
+	fmt.Printf("  API Key: %s%s\n", maskAPIKey(cfg.Deepseek.APIKey), lock("deepseek.api_key"))
+	printProviderOptions("deepseek", cfg.Deepseek, cfg.Policy)
+	fmt.Println()
 	
 	fmt.Println("Gemini Configuration:")
-	fmt.Printf("  Provider: %s\n", cfg.Gemini.Provider)
-	fmt.Printf("  Model: %s\n", cfg.Gemini.Model)
-	fmt.Printf("  API Key: %s\n", maskAPIKey(cfg.Gemini.APIKey))
+	fmt.Printf("  Provider: %s%s\n", cfg.Gemini.Provider, lock("gemini.provider"))
+	fmt.Printf("  Model: %s%s\n", cfg.Gemini.Model, lock("gemini.model"))
+	fmt.Printf("  API Key: %s%s\n", maskAPIKey(cfg.Gemini.APIKey), lock("gemini.api_key"))
+	printProviderOptions("gemini", cfg.Gemini, cfg.Policy)
+	if len(cfg.Gemini.SafetySettings) > 0 {
+		fmt.Printf("  Safety Settings: %s%s\n", formatSafetySettings(cfg.Gemini.SafetySettings), lock("gemini.safety_settings"))
+	}
+	fmt.Println()
+
+	fmt.Println("Commit Configuration:")
+	if cfg.Commit.Format == "" {
+		fmt.Printf("  Format: [default]%s\n", lock("commit.format"))
+	} else {
+		fmt.Printf("  Format: %q%s\n", cfg.Commit.Format, lock("commit.format"))
+	}
+	if len(cfg.Commit.TicketKeys) > 0 {
+		fmt.Printf("  Ticket Keys: %s%s\n", strings.Join(cfg.Commit.TicketKeys, ", "), lock("commit.ticket_keys"))
+	}
+
+	if cfg.Budget.Daily > 0 || cfg.Budget.Monthly > 0 {
+		fmt.Println("\nBudget Configuration:")
+		if cfg.Budget.Daily > 0 {
+			fmt.Printf("  Daily: $%.2f%s\n", cfg.Budget.Daily, lock("budget.daily"))
+		}
+		if cfg.Budget.Monthly > 0 {
+			fmt.Printf("  Monthly: $%.2f%s\n", cfg.Budget.Monthly, lock("budget.monthly"))
+		}
+		onExceeded := cfg.Budget.OnExceeded
+		if onExceeded == "" {
+			onExceeded = "error"
+		}
+		fmt.Printf("  On Exceeded: %s%s\n", onExceeded, lock("budget.on_exceeded"))
+	}
+
+	fmt.Println("\nCache Configuration:")
+	fmt.Printf("  Enabled: %t%s\n", !cfg.Cache.Disabled, lock("cache.enabled"))
+	fmt.Printf("  TTL: %s%s\n", cfg.Cache.ttl(), lock("cache.ttl"))
+	fmt.Printf("  Max Size: %d MB%s\n", cfg.Cache.maxSize()>>20, lock("cache.max_size_mb"))
+
+	fmt.Println("\nRedaction Configuration:")
+	fmt.Printf("  Enabled: %t%s\n", !cfg.Redaction.Disabled, lock("redaction.enabled"))
+	fmt.Printf("  Strict: %t%s\n", cfg.Redaction.Strict, lock("redaction.strict"))
+	fmt.Printf("  Entropy Detector: %t%s\n", !cfg.Redaction.DisableEntropy, lock("redaction.entropy"))
+	if len(cfg.Redaction.Patterns) > 0 {
+		fmt.Printf("  Patterns: %q%s\n", cfg.Redaction.Patterns, lock("redaction.patterns"))
+	}
+
+	if cfg.Diff.MaxFileLines > 0 {
+		fmt.Println("\nDiff Configuration:")
+		fmt.Printf("  Max File Lines: %d%s\n", cfg.Diff.MaxFileLines, lock("diff.max_file_lines"))
+	}
+
+	if len(cfg.Privacy.Include) > 0 || len(cfg.Privacy.Exclude) > 0 {
+		fmt.Println("\nPrivacy Configuration:")
+		if len(cfg.Privacy.Include) > 0 {
+			fmt.Printf("  Include: %s%s\n", strings.Join(cfg.Privacy.Include, ", "), lock("privacy.include"))
+		}
+		if len(cfg.Privacy.Exclude) > 0 {
+			fmt.Printf("  Exclude: %s%s\n", strings.Join(cfg.Privacy.Exclude, ", "), lock("privacy.exclude"))
+		}
+	}
+
+	if len(cfg.Scope.Sources) > 0 || len(cfg.Scope.Components) > 0 {
+		fmt.Println("\nScope Configuration:")
+		if len(cfg.Scope.Sources) > 0 {
+			fmt.Printf("  Sources: %s%s\n", strings.Join(cfg.Scope.Sources, ", "), lock("scope.sources"))
+		}
+		for _, name := range sortedKeys(cfg.Scope.Components) {
+			fmt.Printf("  Component %s: %s%s\n", name, strings.Join(cfg.Scope.Components[name], ", "), lock("scope.components."+name))
+		}
+	}
+
+	if cfg.PR != (PRConfig{}) {
+		fmt.Println("\nPull Request Configuration:")
+		if cfg.PR.Template != "" {
+			fmt.Printf("  Template: %s%s\n", cfg.PR.Template, lock("pr.template"))
+		}
+		if cfg.PR.Base != "" {
+			fmt.Printf("  Base: %s%s\n", cfg.PR.Base, lock("pr.base"))
+		}
+		if cfg.PR.TicketURL != "" {
+			fmt.Printf("  Ticket URL: %s%s\n", cfg.PR.TicketURL, lock("pr.ticket_url"))
+		}
+	}
+
+	fmt.Println("\nHistory Configuration:")
+	fmt.Printf("  Enabled: %t%s\n", !cfg.History.Disabled, lock("history.enabled"))
+	if !cfg.History.Disabled {
+		fmt.Printf("  Examples: %d%s\n", cfg.History.examples(), lock("history.examples"))
+		if cfg.History.Branch != "" {
+			fmt.Printf("  Branch: %s%s\n", cfg.History.Branch, lock("history.branch"))
+		}
+	}
+
+	fmt.Println("\nRetrieval Configuration:")
+	fmt.Printf("  Enabled: %t%s\n", !cfg.Retrieval.Disabled, lock("retrieval.enabled"))
+	if !cfg.Retrieval.Disabled {
+		fmt.Printf("  Method: %s%s\n", cfg.Retrieval.method(), lock("retrieval.method"))
+		fmt.Printf("  Top K: %d%s\n", cfg.Retrieval.topK(), lock("retrieval.top_k"))
+		if cfg.Retrieval.EmbeddingModel != "" {
+			fmt.Printf("  Embedding Model: %s%s\n", cfg.Retrieval.EmbeddingModel, lock("retrieval.embedding_model"))
+		}
+	}
+
+	fmt.Println("\nAudit Configuration:")
+	fmt.Printf("  Enabled: %t%s\n", cfg.Audit.Enabled, lock("audit.enabled"))
+	if cfg.Audit.Enabled {
+		fmt.Printf("  Path: %s%s\n", cfg.Audit.logPath(), lock("audit.path"))
+		fmt.Printf("  Store Prompts: %t%s\n", cfg.Audit.StorePrompts, lock("audit.store_prompts"))
+	}
+
+	if len(cfg.Prices) > 0 {
+		fmt.Println("\nPrices (USD per million tokens, input/output):")
+		for _, model := range sortedKeys(cfg.Prices) {
+			fmt.Printf("  %s: %g/%g%s\n", model, cfg.Prices[model].Input, cfg.Prices[model].Output, lock("prices."+model))
+		}
+	}
 }
 
 func maskAPIKey(key string) string {
@@ -437,18 +1009,142 @@ func maskAPIKey(key string) string {
 	return key[:4] + "..." + key[len(key)-4:]
 }
 
-func generateCommitMessage(prompt string, provider Provider) (string, error) {
+// generateCommitMessage sends req to the selected provider and returns its raw reply,
+// which is a JSON object when req.Output is set and the model supports it.
+// Responses are served from the cache when possible, and the usage of every
+// request sent is recorded in the usage log.
+func generateCommitMessage(req generationRequest, provider Provider) (*generationResult, error) {
 	ctx := context.Background()
 
 	// Get the configuration
 	cfg, err := loadConfig()
 	if err != nil {
-		return "", fmt.Errorf("error loading configuration: %v", err)
+		return nil, fmt.Errorf("error loading configuration: %v", err)
+	}
+
+	actualProvider, pc, section := resolveProvider(cfg, provider)
+	if req.Model != "" {
+		pc.Model = req.Model
 	}
 
+	// Refuse providers and models the organization policy does not allow
+	if err := cfg.Policy.check(actualProvider, section, pc); err != nil {
+		return nil, err
+	}
+
+	// Reuse a previous response to the same request. Cached responses cost
+	// nothing, so they are served even once a budget or rate limit is reached.
+	useCache := !cfg.Cache.Disabled && !req.NoCache
+	lookup := func(key string) (*generationResult, bool) {
+		if !useCache {
+			return nil, false
+		}
+		entry, ok := lookupCache(cfg.Cache, key)
+		if !ok {
+			return nil, false
+		}
+		return &generationResult{
+			Text:     entry.Text,
+			Provider: entry.Provider,
+			Model:    entry.Model,
+			Usage:    entry.Usage,
+			Cached:   true,
+		}, true
+	}
+	key := cacheKey(actualProvider, pc, req)
+	if cached, ok := lookup(key); ok {
+		return cached, nil
+	}
+
+	// The heuristic provider is free and takes no parameters
+	if actualProvider != ProviderHeuristic {
+		// Enforce local budgets, which may switch to a cheaper model whose
+		// response may be cached too
+		model := pc.Model
+		if err := enforceBudget(cfg, actualProvider, section, &pc); err != nil {
+			return nil, err
+		}
+		if err := cfg.Policy.check(actualProvider, section, pc); err != nil {
+			return nil, err
+		}
+		if pc.Model != model {
+			key = cacheKey(actualProvider, pc, req)
+			if cached, ok := lookup(key); ok {
+				return cached, nil
+			}
+		}
+
+		// Reject parameters the model does not accept before spending a request
+		if err := validateGenerationConfig(actualProvider, section, pc); err != nil {
+			return nil, err
+		}
+	}
+
+	// What the provider is sent depends on whether the model supports
+	// structured output, settle it once for the audit log
+	model := pc.Model
+	if model == "" {
+		model = defaultModels[actualProvider]
+	}
+	sent := req.sentTo(actualProvider, model)
+
+	// Generate message using the actual provider
+	var result *generationResult
+	switch actualProvider {
+	case ProviderOpenAI:
+		result, err = generateOpenAICommitMessage(ctx, req, pc)
+	case ProviderClaude:
+		result, err = generateClaudeCommitMessage(ctx, req, pc)
+	case ProviderDeepseek:
+		result, err = generateDeepseekCommitMessage(ctx, req, pc)
+	case ProviderGemini:
+		result, err = generateGeminiCommitMessage(ctx, req, pc)
+	case ProviderHeuristic:
+		result, err = generateHeuristicCommitMessage(req)
+	default:
+		return nil, fmt.Errorf("unsupported provider: %s", actualProvider)
+	}
+
+	// Record what left the machine, whether or not the request succeeded
+	var unsent unsentError
+	if cfg.Audit.Enabled && actualProvider != ProviderHeuristic && !errors.As(err, &unsent) {
+		if err := recordAudit(cfg.Audit, req, sent, actualProvider, model, result, err); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not write the audit log: %v\n", err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result.Provider = actualProvider
+	result.Cost = estimateCost(cfg, result.Model, result.Usage)
+	if err := recordUsage(result); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not record usage: %v\n", err)
+	}
+
+	if useCache {
+		err := storeCache(cfg.Cache, key, cacheEntry{
+			Created:  time.Now(),
+			Provider: result.Provider,
+			Model:    result.Model,
+			Text:     result.Text,
+			Usage:    result.Usage,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not cache response: %v\n", err)
+		}
+	}
+
+	return result, nil
+}
+
+// resolveProvider returns the provider that actually serves requests for
+// provider, after redirection, along with its config and config section.
+func resolveProvider(cfg *Config, provider Provider) (Provider, ProviderConfig, string) {
 	// Check if the provider has a custom provider set
 	var actualProvider Provider
-	var model string
+	var pc ProviderConfig
+	section := string(provider)
 
 	switch provider {
 	case ProviderOpenAI:
@@ -457,216 +1153,392 @@ func generateCommitMessage(prompt string, provider Provider) (string, error) {
 		} else {
 			actualProvider = ProviderOpenAI
 		}
-		model = cfg.OpenAI.Model
+		pc = cfg.OpenAI
 	case ProviderClaude:
 		if cfg.Claude.Provider != "" && cfg.Claude.Provider != string(ProviderClaude) {
 			actualProvider = Provider(cfg.Claude.Provider)
 		} else {
 			actualProvider = ProviderClaude
 		}
-		model = cfg.Claude.Model
+		pc = cfg.Claude
 	case ProviderDeepseek:
 		if cfg.Deepseek.Provider != "" && cfg.Deepseek.Provider != string(ProviderDeepseek) {
 			actualProvider = Provider(cfg.Deepseek.Provider)
 		} else {
 			actualProvider = ProviderDeepseek
 		}
-		model = cfg.Deepseek.Model
+		pc = cfg.Deepseek
 	case ProviderGemini:
 		if cfg.Gemini.Provider != "" && cfg.Gemini.Provider != string(ProviderGemini) {
 			actualProvider = Provider(cfg.Gemini.Provider)
 		} else {
 			actualProvider = ProviderGemini
 		}
-		model = cfg.Gemini.Model
+		pc = cfg.Gemini
 	default:
 		actualProvider = provider
 	}
 
-	// Generate message using the actual provider
-	switch actualProvider {
-	case ProviderOpenAI:
-		return generateOpenAICommitMessage(ctx, prompt, model)
-	case ProviderClaude:
-		return generateClaudeCommitMessage(ctx, prompt, model)
-	case ProviderDeepseek:
-		return generateDeepseekCommitMessage(ctx, prompt, model)
-	case ProviderGemini:
-		return generateGeminiCommitMessage(ctx, prompt, model)
-	default:
-		return "", fmt.Errorf("unsupported provider: %s", actualProvider)
-	}
+	return actualProvider, pc, section
 }
 
-func generateOpenAICommitMessage(ctx context.Context, prompt, model string) (string, error) {
+func generateOpenAICommitMessage(ctx context.Context, req generationRequest, pc ProviderConfig) (*generationResult, error) {
+	// Local OpenAI compatible servers usually do not need a key
 	apiKey := getAPIKey(ProviderOpenAI)
-	if apiKey == "" {
-		return "", fmt.Errorf("OpenAI API key not found. Set it with:\n" +
+	if apiKey == "" && pc.BaseURL == "" {
+		return nil, unsentError{fmt.Errorf("OpenAI API key not found. Set it with:\n" +
 			"export OPENAI_API_KEY=sk-xxxxxxx\n" +
 			"or\n" +
-			"commitly config set openai.api_key sk-xxxxxxx")
+			"commitly config set openai.api_key sk-xxxxxxx")}
 	}
 
 	// Use default model if not specified
+	model := pc.Model
 	if model == "" {
-		model = "gpt-4o"
+		model = defaultModels[ProviderOpenAI]
 	}
 
-	client := openai.NewClient(openaiOption.WithAPIKey(apiKey))
-	
-	response, err := client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+	options := []openaiOption.RequestOption{openaiOption.WithAPIKey(apiKey)}
+	if pc.BaseURL != "" {
+		options = append(options, openaiOption.WithBaseURL(pc.BaseURL))
+	}
+	client := openai.NewClient(options...)
+
+	structured := req.Output != nil && supportsStructuredOutput(ProviderOpenAI, model)
+	params := openai.ChatCompletionNewParams{
 		Model: openai.F(model),
 		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage("You are a commit message generator that creates messages in the conventional commit format. " +
-				"You always follow the format: <type>(<ticket>): <title>\n<optional body>. " +
-				"Types are limited to: feat, fix, docs, style, refactor, test, chore. " +
-				"Keep the title concise and descriptive. Add body only if additional context is needed."),
-			openai.UserMessage(prompt),
+			openai.SystemMessage(req.System),
+			openai.UserMessage(req.promptFor(structured)),
 		}),
-		Temperature: openai.F(0.7),
-	})
+	}
+	caps := modelCapabilitiesFor(ProviderOpenAI, model)
+	if pc.Temperature != nil {
+		params.Temperature = openai.F(*pc.Temperature)
+	} else if caps.Sampling {
+		params.Temperature = openai.F(0.7)
+	}
+	if pc.TopP != nil {
+		params.TopP = openai.F(*pc.TopP)
+	}
+	if pc.MaxTokens != nil {
+		params.MaxCompletionTokens = openai.F(int64(*pc.MaxTokens))
+	}
+	if pc.Seed != nil {
+		params.Seed = openai.F(*pc.Seed)
+	}
+	if len(pc.Stop) > 0 {
+		params.Stop = openai.F[openai.ChatCompletionNewParamsStopUnion](openai.ChatCompletionNewParamsStopArray(pc.Stop))
+	}
+	if pc.ReasoningEffort != "" {
+		params.ReasoningEffort = openai.F(openai.ChatCompletionReasoningEffort(pc.ReasoningEffort))
+	}
+	if structured {
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](openai.ResponseFormatJSONSchemaParam{
+			Type: openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
+			JSONSchema: openai.F(openai.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:        openai.F(req.Output.Name),
+				Description: openai.F(req.Output.Description),
+				Schema:      openai.F[interface{}](req.Output.Schema.jsonSchema()),
+				Strict:      openai.F(true),
+			}),
+		})
+	}
+
+	response, err := client.Chat.Completions.New(ctx, params)
 	if err != nil {
-		return "", fmt.Errorf("OpenAI API error: %v", err)
+		return nil, fmt.Errorf("OpenAI API error: %v", err)
+	}
+
+	if len(response.Choices) == 0 {
+		return nil, fmt.Errorf("empty response from OpenAI API")
+	}
+	if refusal := response.Choices[0].Message.Refusal; refusal != "" {
+		return nil, fmt.Errorf("OpenAI refused the request: %s", refusal)
 	}
 
-	return response.Choices[0].Message.Content, nil
+	return &generationResult{
+		Text:  response.Choices[0].Message.Content,
+		Model: model,
+		Usage: tokenUsage{
+			InputTokens:  int(response.Usage.PromptTokens),
+			OutputTokens: int(response.Usage.CompletionTokens),
+		},
+	}, nil
 }
 
-func generateClaudeCommitMessage(ctx context.Context, prompt, model string) (string, error) {
+func generateClaudeCommitMessage(ctx context.Context, req generationRequest, pc ProviderConfig) (*generationResult, error) {
 	apiKey := getAPIKey(ProviderClaude)
-	if apiKey == "" {
-		return "", fmt.Errorf("Claude API key not found. Set it with:\n" +
+	if apiKey == "" && pc.BaseURL == "" {
+		return nil, unsentError{fmt.Errorf("Claude API key not found. Set it with:\n" +
 			"export ANTHROPIC_API_KEY=sk-ant-xxxxxxx\n" +
 			"or\n" +
-			"commitly config set claude.api_key sk-ant-xxxxxxx")
+			"commitly config set claude.api_key sk-ant-xxxxxxx")}
 	}
 
 	// Use default model if not specified
+	model := pc.Model
 	if model == "" {
-		model = string(anthropic.ModelClaude3Dot5SonnetLatest)
+		model = defaultModels[ProviderClaude]
 	}
 
-	client := anthropic.NewClient(apiKey)
-	
-	response, err := client.CreateMessages(ctx, anthropic.MessagesRequest{
+	var options []anthropic.ClientOption
+	if pc.BaseURL != "" {
+		options = append(options, anthropic.WithBaseURL(pc.BaseURL))
+	}
+	client := anthropic.NewClient(apiKey, options...)
+
+	// Structured output is obtained by forcing a call to a tool whose input
+	// schema is the requested object
+	structured := req.Output != nil && supportsStructuredOutput(ProviderClaude, model)
+	request := anthropic.MessagesRequest{
 		Model: anthropic.Model(model),
 		MultiSystem: []anthropic.MessageSystemPart{
 			{
 				Type: "text",
-				Text: "You are a commit message generator that creates messages in the conventional commit format. " +
-					"You always follow the format: <type>(<ticket>): <title>\n<optional body>. " +
-					"Types are limited to: feat, fix, docs, style, refactor, test, chore. " +
-					"Keep the title concise and descriptive. Add body only if additional context is needed.",
+				Text: req.System,
 			},
 		},
 		Messages: []anthropic.Message{
-			anthropic.NewUserTextMessage(prompt),
+			anthropic.NewUserTextMessage(req.promptFor(structured)),
 		},
-		MaxTokens: 1000,
-	})
+		MaxTokens:     1000,
+		StopSequences: pc.Stop,
+	}
+	if pc.MaxTokens != nil {
+		request.MaxTokens = *pc.MaxTokens
+	}
+	if pc.Temperature != nil {
+		temperature := float32(*pc.Temperature)
+		request.Temperature = &temperature
+	}
+	if pc.TopP != nil {
+		topP := float32(*pc.TopP)
+		request.TopP = &topP
+	}
+	if structured {
+		request.Tools = []anthropic.ToolDefinition{
+			{
+				Name:        req.Output.Name,
+				Description: req.Output.Description,
+				InputSchema: req.Output.Schema.jsonSchema(),
+			},
+		}
+		request.ToolChoice = &anthropic.ToolChoice{Type: "tool", Name: req.Output.Name}
+	}
+
+	response, err := client.CreateMessages(ctx, request)
 	if err != nil {
 		var apiErr *anthropic.APIError
 		if errors.As(err, &apiErr) {
-			return "", fmt.Errorf("Claude API error - Type: %s, Message: %s", apiErr.Type, apiErr.Message)
+			return nil, fmt.Errorf("Claude API error - Type: %s, Message: %s", apiErr.Type, apiErr.Message)
 		}
-		return "", fmt.Errorf("Claude API error: %v", err)
+		return nil, fmt.Errorf("Claude API error: %v", err)
 	}
 
 	if len(response.Content) == 0 {
-		return "", fmt.Errorf("empty response from Claude API")
+		return nil, fmt.Errorf("empty response from Claude API")
 	}
 
-	return response.Content[0].GetText(), nil
+	result := &generationResult{
+		Text:  response.Content[0].GetText(),
+		Model: model,
+		Usage: tokenUsage{
+			InputTokens: response.Usage.InputTokens + response.Usage.CacheCreationInputTokens +
+				response.Usage.CacheReadInputTokens,
+			OutputTokens: response.Usage.OutputTokens,
+		},
+	}
+	if structured {
+		for _, content := range response.Content {
+			if content.Type == anthropic.MessagesContentTypeToolUse && content.MessageContentToolUse != nil {
+				result.Text = string(content.MessageContentToolUse.Input)
+				break
+			}
+		}
+	}
+
+	return result, nil
 }
 
-func generateDeepseekCommitMessage(ctx context.Context, prompt, model string) (string, error) {
+func generateDeepseekCommitMessage(ctx context.Context, req generationRequest, pc ProviderConfig) (*generationResult, error) {
 	apiKey := getAPIKey(ProviderDeepseek)
-	if apiKey == "" {
-		return "", fmt.Errorf("Deepseek API key not found. Set it with:\n" +
+	if apiKey == "" && pc.BaseURL == "" {
+		return nil, unsentError{fmt.Errorf("Deepseek API key not found. Set it with:\n" +
 			"export DEEPSEEK_API_KEY=xxxxxxx\n" +
 			"or\n" +
-			"commitly config set deepseek.api_key xxxxxxx")
+			"commitly config set deepseek.api_key xxxxxxx")}
 	}
 
 	// Use default model if not specified
+	model := pc.Model
 	if model == "" {
-		model = deepseek.DeepSeekChat
+		model = defaultModels[ProviderDeepseek]
 	}
 
 	client := deepseek.NewClient(apiKey)
-	
-	response, err := client.CreateChatCompletion(ctx, &deepseek.ChatCompletionRequest{
+	if pc.BaseURL != "" {
+		client.BaseURL = strings.TrimSuffix(pc.BaseURL, "/") + "/"
+	}
+
+	structured := req.Output != nil && supportsStructuredOutput(ProviderDeepseek, model)
+	system := req.systemFor(ProviderDeepseek, structured)
+	request := &deepseek.ChatCompletionRequest{
 		Model: model,
 		Messages: []deepseek.ChatCompletionMessage{
-			{Role: "system", Content: "You are a commit message generator that creates messages in the conventional commit format. " +
-				"You always follow the format: <type>(<ticket>): <title>\n<optional body>. " +
-				"Types are limited to: feat, fix, docs, style, refactor, test, chore. " +
-				"Keep the title concise and descriptive. Add body only if additional context is needed."},
-			{Role: "user", Content: prompt},
+			{Role: "system", Content: system},
+			{Role: "user", Content: req.promptFor(structured)},
 		},
-	})
+		Stop: pc.Stop,
+	}
+	if pc.Temperature != nil {
+		request.Temperature = deepseekFloat(*pc.Temperature)
+	}
+	if pc.TopP != nil {
+		request.TopP = deepseekFloat(*pc.TopP)
+	}
+	if pc.MaxTokens != nil {
+		request.MaxTokens = *pc.MaxTokens
+	}
+	if structured {
+		request.ResponseFormat = &deepseek.ResponseFormat{Type: "json_object"}
+	}
+
+	response, err := client.CreateChatCompletion(ctx, request)
 	if err != nil {
-		return "", fmt.Errorf("Deepseek API error: %v", err)
+		return nil, fmt.Errorf("Deepseek API error: %v", err)
+	}
+
+	if len(response.Choices) == 0 {
+		return nil, fmt.Errorf("empty response from Deepseek API")
 	}
 
-	return response.Choices[0].Message.Content, nil
+	return &generationResult{
+		Text:  response.Choices[0].Message.Content,
+		Model: model,
+		Usage: tokenUsage{
+			InputTokens:  response.Usage.PromptTokens,
+			OutputTokens: response.Usage.CompletionTokens,
+		},
+	}, nil
 }
 
-func generateGeminiCommitMessage(ctx context.Context, prompt, model string) (string, error) {
+func generateGeminiCommitMessage(ctx context.Context, req generationRequest, pc ProviderConfig) (*generationResult, error) {
 	apiKey := getAPIKey(ProviderGemini)
 	if apiKey == "" {
-		return "", fmt.Errorf("Gemini API key not found. Set it with:\n" +
+		return nil, unsentError{fmt.Errorf("Gemini API key not found. Set it with:\n" +
 			"export GEMINI_API_KEY=xxxxxxx\n" +
 			"or\n" +
-			"commitly config set gemini.api_key xxxxxxx")
+			"commitly config set gemini.api_key xxxxxxx")}
 	}
 
 	// Use default model if not specified
+	model := pc.Model
 	if model == "" {
-		model = "gemini-1.5-flash-latest"
+		model = defaultModels[ProviderGemini]
 	}
 
 	client, err := genai.NewClient(ctx, googleOption.WithAPIKey(apiKey))
 	if err != nil {
-		return "", fmt.Errorf("error creating Gemini client: %v", err)
+		return nil, unsentError{fmt.Errorf("error creating Gemini client: %v", err)}
 	}
 	defer client.Close()
 
 	geminiModel := client.GenerativeModel(model)
-	
-	// Create safety settings and generation config if needed
-	safetySettings := []*genai.SafetySetting{
-		{
-			Category:  genai.HarmCategoryHarassment,
-			Threshold: genai.HarmBlockNone,
-		},
+	geminiModel.SystemInstruction = genai.NewUserContent(genai.Text(req.System))
+
+	safetySettings, err := geminiSafetySettings(pc.SafetySettings)
+	if err != nil {
+		return nil, unsentError{err}
 	}
 	geminiModel.SafetySettings = safetySettings
-	
-	// Add system instruction as part of the conversation
-	systemPrompt := "You are a commit message generator that creates messages in the conventional commit format. " +
-		"You always follow the format: <type>(<ticket>): <title>\n<optional body>. " +
-		"Types are limited to: feat, fix, docs, style, refactor, test, chore. " +
-		"Keep the title concise and descriptive. Add body only if additional context is needed."
-	
-	// Create chat session with system prompt
-	chat := geminiModel.StartChat()
-	_, err = chat.SendMessage(ctx, genai.Text(systemPrompt))
-	if err != nil {
-		return "", fmt.Errorf("error sending system prompt to Gemini: %v", err)
+
+	if pc.Temperature != nil {
+		geminiModel.SetTemperature(float32(*pc.Temperature))
 	}
-	
-	// Send the actual prompt
-	resp, err := chat.SendMessage(ctx, genai.Text(prompt))
+	if pc.TopP != nil {
+		geminiModel.SetTopP(float32(*pc.TopP))
+	}
+	if pc.MaxTokens != nil {
+		geminiModel.SetMaxOutputTokens(int32(*pc.MaxTokens))
+	}
+	geminiModel.StopSequences = pc.Stop
+
+	structured := req.Output != nil && supportsStructuredOutput(ProviderGemini, model)
+	if structured {
+		geminiModel.ResponseMIMEType = "application/json"
+		geminiModel.ResponseSchema = req.Output.Schema.geminiSchema()
+	}
+
+	resp, err := geminiModel.GenerateContent(ctx, genai.Text(req.promptFor(structured)))
 	if err != nil {
-		return "", fmt.Errorf("Gemini API error: %v", err)
+		var blockedErr *genai.BlockedError
+		if errors.As(err, &blockedErr) {
+			return nil, geminiBlockedError(blockedErr)
+		}
+		return nil, fmt.Errorf("Gemini API error: %v", err)
 	}
 
-	if len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("empty response from Gemini API")
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return nil, fmt.Errorf("empty response from Gemini API")
 	}
 
-	return fmt.Sprintf("%v", resp.Candidates[0].Content.Parts[0]), nil
+	candidate := resp.Candidates[0]
+	switch candidate.FinishReason {
+	case genai.FinishReasonUnspecified, genai.FinishReasonStop:
+	case genai.FinishReasonMaxTokens:
+		return nil, fmt.Errorf("Gemini response was truncated at the max output token limit, raise it with:\n" +
+			"commitly config set gemini.max_tokens 2048")
+	default:
+		return nil, fmt.Errorf("Gemini stopped generating, finish reason: %s", candidate.FinishReason)
+	}
+
+	// Concatenate every text part of the candidate
+	var text strings.Builder
+	for _, part := range candidate.Content.Parts {
+		if t, ok := part.(genai.Text); ok {
+			text.WriteString(string(t))
+		}
+	}
+	if text.Len() == 0 {
+		return nil, fmt.Errorf("empty response from Gemini API")
+	}
+
+	result := &generationResult{Text: text.String(), Model: model}
+	if resp.UsageMetadata != nil {
+		result.Usage = tokenUsage{
+			InputTokens:  int(resp.UsageMetadata.PromptTokenCount),
+			OutputTokens: int(resp.UsageMetadata.CandidatesTokenCount),
+		}
+	}
+
+	return result, nil
+}
+
+// geminiBlockedError describes why Gemini blocked the prompt or the response.
+func geminiBlockedError(err *genai.BlockedError) error {
+	var ratings []*genai.SafetyRating
+	reason := ""
+	if err.PromptFeedback != nil {
+		reason = fmt.Sprintf("prompt blocked, reason: %s", err.PromptFeedback.BlockReason)
+		ratings = err.PromptFeedback.SafetyRatings
+	} else if err.Candidate != nil {
+		reason = fmt.Sprintf("response blocked, finish reason: %s", err.Candidate.FinishReason)
+		ratings = err.Candidate.SafetyRatings
+	}
+
+	var flagged []string
+	for _, rating := range ratings {
+		if rating.Blocked {
+			flagged = append(flagged, fmt.Sprintf("%s (%s)", rating.Category, rating.Probability))
+		}
+	}
+	if len(flagged) > 0 {
+		reason += ", categories: " + strings.Join(flagged, ", ")
+	}
+
+	return fmt.Errorf("Gemini %s. Adjust the thresholds with:\n"+
+		"commitly config set gemini.safety_settings harassment=none,dangerous_content=only_high", reason)
 }
 
 func getAPIKey(provider Provider) string {
@@ -710,7 +1582,8 @@ func getAPIKey(provider Provider) string {
 
 // getGitDiff tries to get the diff from the last stash.
 // If no stash is available, it uses "git diff".
-func getGitDiff() (string, error) {
+// The returned source tells where the two sides of the diff can be read.
+func getGitDiff() (string, diffSource, error) {
 	// Try to get diff from last stash
 	cmd := exec.Command("git", "stash", "show", "-p")
 	output, err := cmd.CombinedOutput()
@@ -719,19 +1592,9 @@ func getGitDiff() (string, error) {
 		cmd2 := exec.Command("git", "diff")
 		output2, err2 := cmd2.CombinedOutput()
 		if err2 != nil {
-			return "", fmt.Errorf("error executing 'git diff': %v, output: %s", err2, string(output2))
+			return "", diffSource{}, fmt.Errorf("error executing 'git diff': %v, output: %s", err2, string(output2))
 		}
-		return string(output2), nil
-	}
-	return string(output), nil
-}
-
-// getCommitHistory gets the messages from the last 10 commits.
-func getCommitHistory() (string, error) {
-	cmd := exec.Command("git", "log", "--pretty=format:%s", "-n", "10")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("error executing 'git log': %v, output: %s", err, string(output))
+		return string(output2), diffSource{}, nil
 	}
-	return string(output), nil
+	return string(output), diffSource{Before: "stash@{0}^1", After: "stash@{0}"}, nil
 }
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+// setProviderValue sets a generation parameter or request limit on pc. It
+// reports false when key is not one of them.
+func setProviderValue(pc *ProviderConfig, key, value string) (bool, error) {
+	switch key {
+	case "temperature":
+		if value == "" {
+			pc.Temperature = nil
+			return true, nil
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || v < 0 || v > 2 {
+			return true, fmt.Errorf("temperature must be a number between 0 and 2")
+		}
+		pc.Temperature = &v
+	case "top_p":
+		if value == "" {
+			pc.TopP = nil
+			return true, nil
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || v <= 0 || v > 1 {
+			return true, fmt.Errorf("top_p must be a number greater than 0 and at most 1")
+		}
+		pc.TopP = &v
+	case "max_tokens":
+		if value == "" {
+			pc.MaxTokens = nil
+			return true, nil
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil || v <= 0 {
+			return true, fmt.Errorf("max_tokens must be a positive integer")
+		}
+		pc.MaxTokens = &v
+	case "seed":
+		if value == "" {
+			pc.Seed = nil
+			return true, nil
+		}
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return true, fmt.Errorf("seed must be an integer")
+		}
+		pc.Seed = &v
+	case "stop":
+		pc.Stop = splitList(value)
+	case "reasoning_effort":
+		value = strings.ToLower(value)
+		if value != "" && value != "low" && value != "medium" && value != "high" {
+			return true, fmt.Errorf("reasoning_effort must be one of: low, medium, high")
+		}
+		pc.ReasoningEffort = value
+	case "max_requests_per_minute":
+		if value == "" {
+			pc.MaxRequestsPerMinute = 0
+			return true, nil
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil || v < 0 {
+			return true, fmt.Errorf("max_requests_per_minute must be a positive integer")
+		}
+		pc.MaxRequestsPerMinute = v
+	case "budget_model":
+		pc.BudgetModel = value
+	case "base_url":
+		if value != "" {
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return true, fmt.Errorf("base_url must be an http or https URL")
+			}
+		}
+		pc.BaseURL = value
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// splitList splits a comma separated config value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getProviderValue returns a generation parameter or request limit of pc as
+// a string. It reports false when key is not one of them.
+func getProviderValue(pc ProviderConfig, key string) (string, bool) {
+	switch key {
+	case "temperature":
+		return formatFloat(pc.Temperature), true
+	case "top_p":
+		return formatFloat(pc.TopP), true
+	case "max_tokens":
+		if pc.MaxTokens == nil {
+			return "", true
+		}
+		return strconv.Itoa(*pc.MaxTokens), true
+	case "seed":
+		if pc.Seed == nil {
+			return "", true
+		}
+		return strconv.FormatInt(*pc.Seed, 10), true
+	case "stop":
+		return strings.Join(pc.Stop, ","), true
+	case "reasoning_effort":
+		return pc.ReasoningEffort, true
+	case "max_requests_per_minute":
+		if pc.MaxRequestsPerMinute == 0 {
+			return "", true
+		}
+		return strconv.Itoa(pc.MaxRequestsPerMinute), true
+	case "budget_model":
+		return pc.BudgetModel, true
+	case "base_url":
+		return pc.BaseURL, true
+	}
+	return "", false
+}
+
+func formatFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+// printProviderOptions prints the generation parameters and request limits
+// that are set on pc, marking the ones locked by policy.
+func printProviderOptions(section string, pc ProviderConfig, policy *Policy) {
+	lock := func(key string) string { return policy.lockMark(section + "." + key) }
+	if pc.BaseURL != "" {
+		fmt.Printf("  Base URL: %s%s\n", pc.BaseURL, lock("base_url"))
+	}
+	if pc.Temperature != nil {
+		fmt.Printf("  Temperature: %s%s\n", formatFloat(pc.Temperature), lock("temperature"))
+	}
+	if pc.TopP != nil {
+		fmt.Printf("  Top P: %s%s\n", formatFloat(pc.TopP), lock("top_p"))
+	}
+	if pc.MaxTokens != nil {
+		fmt.Printf("  Max Tokens: %d%s\n", *pc.MaxTokens, lock("max_tokens"))
+	}
+	if pc.Seed != nil {
+		fmt.Printf("  Seed: %d%s\n", *pc.Seed, lock("seed"))
+	}
+	if len(pc.Stop) > 0 {
+		fmt.Printf("  Stop: %q%s\n", pc.Stop, lock("stop"))
+	}
+	if pc.ReasoningEffort != "" {
+		fmt.Printf("  Reasoning Effort: %s%s\n", pc.ReasoningEffort, lock("reasoning_effort"))
+	}
+	if pc.MaxRequestsPerMinute > 0 {
+		fmt.Printf("  Max Requests Per Minute: %d%s\n", pc.MaxRequestsPerMinute, lock("max_requests_per_minute"))
+	}
+	if pc.BudgetModel != "" {
+		fmt.Printf("  Budget Model: %s%s\n", pc.BudgetModel, lock("budget_model"))
+	}
+}
+
+// modelCapabilities lists the generation parameters a model accepts.
+type modelCapabilities struct {
+	// Sampling covers temperature and top_p
+	Sampling        bool
+	MaxTemperature  float64
+	Seed            bool
+	Stop            bool
+	MaxStop         int
+	ReasoningEffort bool
+}
+
+// modelCapabilitiesFor returns what provider and model accept, as far as
+// commitly can map it onto the provider SDK.
+func modelCapabilitiesFor(provider Provider, model string) modelCapabilities {
+	model = strings.ToLower(model)
+	switch provider {
+	case ProviderOpenAI:
+		if isOpenAIReasoningModel(model) {
+			// Reasoning models reject sampling parameters and stop sequences
+			return modelCapabilities{
+				Seed:            true,
+				ReasoningEffort: !strings.HasPrefix(model, "o1-mini") && !strings.HasPrefix(model, "o1-preview"),
+			}
+		}
+		return modelCapabilities{Sampling: true, MaxTemperature: 2, Seed: true, Stop: true, MaxStop: 4}
+	case ProviderClaude:
+		return modelCapabilities{Sampling: true, MaxTemperature: 1, Stop: true}
+	case ProviderDeepseek:
+		if model == deepseekReasonerModel {
+			return modelCapabilities{Stop: true}
+		}
+		return modelCapabilities{Sampling: true, MaxTemperature: 2, Stop: true, MaxStop: 16}
+	case ProviderGemini:
+		return modelCapabilities{Sampling: true, MaxTemperature: 2, Stop: true, MaxStop: 5}
+	}
+	return modelCapabilities{}
+}
+
+func isOpenAIReasoningModel(model string) bool {
+	for _, prefix := range []string{"o1", "o3", "o4", "gpt-5"} {
+		if strings.HasPrefix(model, prefix) && !strings.HasPrefix(model, "gpt-5-chat") {
+			return true
+		}
+	}
+	return false
+}
+
+// validateGenerationConfig checks the generation parameters of pc against the
+// capabilities of the model. section is the config section pc came from.
+func validateGenerationConfig(provider Provider, section string, pc ProviderConfig) error {
+	caps := modelCapabilitiesFor(provider, pc.Model)
+	unsupported := func(param string) error {
+		return fmt.Errorf("%s model %q does not support %s, unset it with:\n"+
+			"commitly config set %s.%s \"\"", provider, pc.Model, param, section, param)
+	}
+
+	if pc.Temperature != nil {
+		if !caps.Sampling {
+			return unsupported("temperature")
+		}
+		if *pc.Temperature > caps.MaxTemperature {
+			return fmt.Errorf("%s temperature must be at most %s, got %s",
+				provider, strconv.FormatFloat(caps.MaxTemperature, 'f', -1, 64), formatFloat(pc.Temperature))
+		}
+	}
+	if pc.TopP != nil && !caps.Sampling {
+		return unsupported("top_p")
+	}
+	if pc.Seed != nil && !caps.Seed {
+		return unsupported("seed")
+	}
+	if len(pc.Stop) > 0 {
+		if !caps.Stop {
+			return unsupported("stop")
+		}
+		if caps.MaxStop > 0 && len(pc.Stop) > caps.MaxStop {
+			return fmt.Errorf("%s accepts at most %d stop sequences, got %d", provider, caps.MaxStop, len(pc.Stop))
+		}
+	}
+	if pc.ReasoningEffort != "" && !caps.ReasoningEffort {
+		return unsupported("reasoning_effort")
+	}
+	if pc.BaseURL != "" && provider == ProviderGemini {
+		return fmt.Errorf("gemini does not support a custom base_url, unset it with:\n"+
+			"commitly config set %s.base_url \"\"", section)
+	}
+	return nil
+}
+
+// deepseekFloat converts a parameter for the Deepseek SDK, which omits zero
+// values from the request. Zero is sent as the smallest positive float so an
+// explicit 0 is not silently replaced by the server default.
+func deepseekFloat(v float64) float32 {
+	if v == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+	return float32(v)
+}
+
+var geminiHarmCategories = map[string]genai.HarmCategory{
+	"harassment":        genai.HarmCategoryHarassment,
+	"hate_speech":       genai.HarmCategoryHateSpeech,
+	"sexually_explicit": genai.HarmCategorySexuallyExplicit,
+	"dangerous_content": genai.HarmCategoryDangerousContent,
+}
+
+var geminiBlockThresholds = map[string]genai.HarmBlockThreshold{
+	"none":             genai.HarmBlockNone,
+	"only_high":        genai.HarmBlockOnlyHigh,
+	"medium_and_above": genai.HarmBlockMediumAndAbove,
+	"low_and_above":    genai.HarmBlockLowAndAbove,
+}
+
+// parseSafetySettings parses "category=threshold,..." into a settings map.
+func parseSafetySettings(value string) (map[string]string, error) {
+	settings := map[string]string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		category, threshold, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid safety setting %q, expected category=threshold", item)
+		}
+		category = strings.ToLower(strings.TrimSpace(category))
+		threshold = strings.ToLower(strings.TrimSpace(threshold))
+		if _, ok := geminiHarmCategories[category]; !ok {
+			return nil, fmt.Errorf("unknown harm category %q, expected one of: %s", category, strings.Join(sortedKeys(geminiHarmCategories), ", "))
+		}
+		if _, ok := geminiBlockThresholds[threshold]; !ok {
+			return nil, fmt.Errorf("unknown block threshold %q, expected one of: %s", threshold, strings.Join(sortedKeys(geminiBlockThresholds), ", "))
+		}
+		settings[category] = threshold
+	}
+	return settings, nil
+}
+
+// formatSafetySettings is the inverse of parseSafetySettings.
+func formatSafetySettings(settings map[string]string) string {
+	var items []string
+	for _, category := range sortedKeys(settings) {
+		items = append(items, category+"="+settings[category])
+	}
+	return strings.Join(items, ",")
+}
+
+// geminiSafetySettings builds the Gemini safety settings. Categories that are
+// not configured are not blocked, since diffs of security related code
+// otherwise trip the filters.
+func geminiSafetySettings(settings map[string]string) ([]*genai.SafetySetting, error) {
+	var out []*genai.SafetySetting
+	for _, name := range sortedKeys(geminiHarmCategories) {
+		threshold := genai.HarmBlockNone
+		if value, ok := settings[name]; ok {
+			t, ok := geminiBlockThresholds[value]
+			if !ok {
+				return nil, fmt.Errorf("unknown Gemini block threshold %q for %s", value, name)
+			}
+			threshold = t
+		}
+		out = append(out, &genai.SafetySetting{
+			Category:  geminiHarmCategories[name],
+			Threshold: threshold,
+		})
+	}
+	return out, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
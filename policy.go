@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Policy is a system-wide file, managed by an organization, that overrides
+// the user config. Users cannot change the keys it locks.
+type Policy struct {
+	// AllowedProviders lists the providers that may be used, any when empty
+	AllowedProviders []string `json:"allowed_providers,omitempty"`
+	// AllowedModels maps a provider to the model name globs it may use.
+	// Providers without an entry may use any model.
+	AllowedModels map[string][]string `json:"allowed_models,omitempty"`
+	// RequireRedaction keeps secret redaction enabled
+	RequireRedaction bool `json:"require_redaction,omitempty"`
+	// LocalOnlyRemotes are globs of remote URLs, such as
+	// "github.com/acme/*", whose repositories may only use a provider with a
+	// base_url on this machine
+	LocalOnlyRemotes []string `json:"local_only_remotes,omitempty"`
+	// ForbiddenKeys are config key globs, such as "*.api_key", that users
+	// cannot set. They keep their default value.
+	ForbiddenKeys []string `json:"forbidden_keys,omitempty"`
+	// Settings are config values enforced over the user config, keyed like
+	// "commitly config set"
+	Settings map[string]string `json:"settings,omitempty"`
+
+	path string
+}
+
+// getPolicyPath returns the location of the organization policy file.
+func getPolicyPath() string {
+	switch runtime.GOOS {
+	case "windows":
+		programData := os.Getenv("ProgramData")
+		if programData == "" {
+			programData = `C:\ProgramData`
+		}
+		return filepath.Join(programData, "commitly", "policy.json")
+	case "darwin":
+		return "/Library/Application Support/commitly/policy.json"
+	}
+	return "/etc/commitly/policy.json"
+}
+
+// loadPolicy reads the organization policy, returning nil when there is none.
+// A policy that cannot be read is an error rather than being ignored.
+func loadPolicy() (*Policy, error) {
+	policyPath := getPolicyPath()
+	data, err := os.ReadFile(policyPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy file: %v", err)
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("error parsing policy file %s: %v", policyPath, err)
+	}
+	policy.path = policyPath
+	return &policy, nil
+}
+
+// policyKeys lists every key of "commitly config set" except the per-model
+// prices and the scope components, which are matched against the configured
+// ones.
+func policyKeys() []string {
+	var keys []string
+	for _, section := range []string{"openai", "claude", "deepseek", "gemini"} {
+		for _, key := range []string{"provider", "api_key", "model", "base_url", "temperature", "top_p",
+			"max_tokens", "seed", "stop", "reasoning_effort", "max_requests_per_minute", "budget_model"} {
+			keys = append(keys, section+"."+key)
+		}
+	}
+	return append(keys,
+		"gemini.safety_settings", "default.provider", "commit.format", "commit.ticket_keys",
+		"budget.daily", "budget.monthly", "budget.on_exceeded",
+		"cache.enabled", "cache.ttl", "cache.max_size_mb",
+		"redaction.enabled", "redaction.strict", "redaction.entropy", "redaction.patterns",
+		"privacy.include", "privacy.exclude", "diff.max_file_lines",
+		"audit.enabled", "audit.path", "audit.store_prompts", "scope.sources",
+		"pr.template", "pr.base", "pr.ticket_url",
+		"history.enabled", "history.examples", "history.branch",
+		"retrieval.enabled", "retrieval.method", "retrieval.top_k", "retrieval.embedding_model",
+	)
+}
+
+// forbids reports whether key matches one of the forbidden key globs.
+func (p *Policy) forbids(key string) bool {
+	if p == nil {
+		return false
+	}
+	for _, pattern := range p.ForbiddenKeys {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// locks reports whether users are prevented from changing key.
+func (p *Policy) locks(key string) bool {
+	if p == nil {
+		return false
+	}
+	if _, ok := p.Settings[key]; ok {
+		return true
+	}
+	return p.forbids(key) || (p.RequireRedaction && key == "redaction.enabled")
+}
+
+// lockMark returns the annotation shown next to locked values.
+func (p *Policy) lockMark(key string) string {
+	if p.locks(key) {
+		return " (locked by policy)"
+	}
+	return ""
+}
+
+// applyPolicy overrides cfg with policy: forbidden keys are reset to their
+// default and the enforced settings are applied on top.
+func applyPolicy(cfg *Config, policy *Policy) error {
+	cfg.Policy = policy
+	if policy == nil {
+		return nil
+	}
+
+	defaults := defaultConfig()
+	for _, key := range policyKeys() {
+		if !policy.forbids(key) {
+			continue
+		}
+		value, err := configValue(defaults, key)
+		if err != nil {
+			return err
+		}
+		if err := applyConfigValue(cfg, key, value); err != nil {
+			return err
+		}
+	}
+	for model := range cfg.Prices {
+		if policy.forbids("prices." + model) {
+			delete(cfg.Prices, model)
+		}
+	}
+	for name := range cfg.Scope.Components {
+		if policy.forbids("scope.components." + name) {
+			delete(cfg.Scope.Components, name)
+		}
+	}
+
+	for _, key := range sortedKeys(policy.Settings) {
+		if err := applyConfigValue(cfg, key, policy.Settings[key]); err != nil {
+			return fmt.Errorf("invalid setting %s in policy file %s: %v", key, policy.path, err)
+		}
+	}
+	if policy.RequireRedaction {
+		cfg.Redaction.Disabled = false
+	}
+	return nil
+}
+
+// check returns an error when the policy does not allow sending a request to
+// provider with pc. section is the config section pc came from.
+func (p *Policy) check(provider Provider, section string, pc ProviderConfig) error {
+	if p == nil {
+		return nil
+	}
+
+	if len(p.AllowedProviders) > 0 && !containsFold(p.AllowedProviders, string(provider)) {
+		return fmt.Errorf("provider %s is not allowed by the policy in %s, allowed providers: %s",
+			provider, p.path, strings.Join(p.AllowedProviders, ", "))
+	}
+
+	model := pc.Model
+	if model == "" {
+		model = defaultModels[provider]
+	}
+	if models, ok := p.AllowedModels[string(provider)]; ok && !matchesAny(model, models) {
+		return fmt.Errorf("model %s is not allowed for %s by the policy in %s, allowed models: %s",
+			model, provider, p.path, strings.Join(models, ", "))
+	}
+
+	if len(p.LocalOnlyRemotes) > 0 && !isLocalProvider(provider, pc) {
+		for _, remote := range gitRemoteURLs() {
+			if matchesRemote(p.LocalOnlyRemotes, remote) {
+				return fmt.Errorf("the policy in %s only allows local providers for %s, point %s at a local server with:\n"+
+					"commitly config set %s.base_url http://localhost:11434/v1", p.path, remote, section, section)
+			}
+		}
+	}
+	return nil
+}
+
+func containsFold(items []string, s string) bool {
+	for _, item := range items {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalProvider reports whether requests to provider stay on this machine.
+func isLocalProvider(provider Provider, pc ProviderConfig) bool {
+	return provider == ProviderHeuristic || isLocalEndpoint(pc.BaseURL)
+}
+
+// isLocalEndpoint reports whether baseURL points at this machine.
+func isLocalEndpoint(baseURL string) bool {
+	if baseURL == "" {
+		return false
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// gitRemoteURLs returns the URLs of the remotes of the current repository.
+func gitRemoteURLs() []string {
+	output, err := exec.Command("git", "remote", "-v").Output()
+	if err != nil {
+		return nil
+	}
+	var urls []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && !seen[fields[1]] {
+			seen[fields[1]] = true
+			urls = append(urls, fields[1])
+		}
+	}
+	return urls
+}
+
+var scpRemote = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+
+// normalizeRemote reduces the URL forms git accepts to "host/path", e.g.
+// "git@github.com:acme/app.git" and "https://github.com/acme/app" both
+// become "github.com/acme/app".
+func normalizeRemote(remote string) string {
+	if u, err := url.Parse(remote); err == nil && u.Scheme != "" && u.Host != "" {
+		remote = u.Host + u.Path
+	} else if m := scpRemote.FindStringSubmatch(remote); m != nil {
+		remote = m[1] + "/" + m[2]
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(remote, "/"), ".git")
+}
+
+// matchesRemote matches a remote URL against globs in which "*" matches any
+// run of characters, slashes included.
+func matchesRemote(patterns []string, remote string) bool {
+	remote = normalizeRemote(remote)
+	for _, pattern := range patterns {
+		expr := strings.ReplaceAll(regexp.QuoteMeta(normalizeRemote(pattern)), `\*`, ".*")
+		if ok, _ := regexp.MatchString("(?i)^"+expr+"$", remote); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// printPolicy prints the restrictions of the policy that are not config keys.
+func printPolicy(policy *Policy) {
+	if len(policy.AllowedProviders) > 0 {
+		fmt.Printf("  Allowed Providers: %s\n", strings.Join(policy.AllowedProviders, ", "))
+	}
+	for _, provider := range sortedKeys(policy.AllowedModels) {
+		fmt.Printf("  Allowed %s Models: %s\n", provider, strings.Join(policy.AllowedModels[provider], ", "))
+	}
+	if policy.RequireRedaction {
+		fmt.Println("  Redaction: required")
+	}
+	if len(policy.LocalOnlyRemotes) > 0 {
+		fmt.Printf("  Local Providers Only For: %s\n", strings.Join(policy.LocalOnlyRemotes, ", "))
+	}
+	if len(policy.ForbiddenKeys) > 0 {
+		fmt.Printf("  Forbidden Keys: %s\n", strings.Join(policy.ForbiddenKeys, ", "))
+	}
+}
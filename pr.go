@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// PRConfig configures the pull request descriptions of "commitly pr"
+type PRConfig struct {
+	// Template is the path of a text/template file rendering the body, the
+	// built-in layout when empty
+	Template string `json:"template,omitempty"`
+	// Base is the branch pull requests are opened against, detected when empty
+	Base string `json:"base,omitempty"`
+	// TicketURL links tickets, with "{ticket}" replaced by the ticket
+	TicketURL string `json:"ticket_url,omitempty"`
+}
+
+// setPRValue sets a key of the pr config section.
+func setPRValue(pr *PRConfig, key, value string) error {
+	switch key {
+	case "template":
+		if value != "" {
+			if _, err := os.Stat(value); err != nil {
+				return fmt.Errorf("pr.template must be the path of a template file: %v", err)
+			}
+		}
+		pr.Template = value
+	case "base":
+		pr.Base = value
+	case "ticket_url":
+		if value != "" && !strings.Contains(value, "{ticket}") {
+			return fmt.Errorf("pr.ticket_url must contain {ticket}, e.g. https://acme.atlassian.net/browse/{ticket}")
+		}
+		pr.TicketURL = value
+	default:
+		return fmt.Errorf("unknown key for pr: %s", key)
+	}
+	return nil
+}
+
+// getPRValue returns a key of the pr config section.
+func getPRValue(pr PRConfig, key string) (string, bool) {
+	switch key {
+	case "template":
+		return pr.Template, true
+	case "base":
+		return pr.Base, true
+	case "ticket_url":
+		return pr.TicketURL, true
+	}
+	return "", false
+}
+
+// ticketLink is a ticket and its URL, empty when pr.ticket_url is not set
+type ticketLink struct {
+	ID  string `json:"id"`
+	URL string `json:"url,omitempty"`
+}
+
+// Markdown returns the ticket as a markdown link when it has a URL.
+func (t ticketLink) Markdown() string {
+	if t.URL == "" {
+		return t.ID
+	}
+	return "[" + t.ID + "](" + t.URL + ")"
+}
+
+// pullRequest is the data of the pull request template
+type pullRequest struct {
+	Title          string       `json:"title"`
+	Summary        string       `json:"summary"`
+	Changes        []string     `json:"changes"`
+	Testing        []string     `json:"testing"`
+	BreakingChange string       `json:"breaking_change"`
+	Tickets        []ticketLink `json:"-"`
+	// Commits are the subjects of the commits of the branch
+	Commits []string `json:"-"`
+	Base    string   `json:"-"`
+}
+
+const defaultPRTemplate = `## Summary
+
+{{.Summary}}
+{{- if .Changes}}
+
+## Changes
+{{range .Changes}}
+- {{.}}
+{{- end}}
+{{- end}}
+{{- if .BreakingChange}}
+
+## Breaking Changes
+
+{{.BreakingChange}}
+{{- end}}
+{{- if .Testing}}
+
+## Testing
+{{range .Testing}}
+- {{.}}
+{{- end}}
+{{- end}}
+{{- if .Tickets}}
+
+## Tickets
+{{range .Tickets}}
+- {{.Markdown}}
+{{- end}}
+{{- end}}
+`
+
+const prSystemPrompt = "You write pull request descriptions for reviewers. Be concise and factual, " +
+	"describe why the change is made and what reviewers should pay attention to, and never invent tests that the diff does not show."
+
+var prOutput = &structuredOutput{
+	Name:        "pull_request",
+	Description: "The title and description of a pull request.",
+	Schema: &schema{
+		Type: "object",
+		Properties: map[string]*schema{
+			"title":           {Type: "string", Description: "Conventional commit style title, e.g. 'feat(ABC-1): add login'."},
+			"summary":         {Type: "string", Description: "One short paragraph on what the pull request does and why."},
+			"changes":         {Type: "array", Description: "Notable changes, one per item.", Items: &schema{Type: "string"}},
+			"testing":         {Type: "array", Description: "How the change is tested or should be verified, one per item.", Items: &schema{Type: "string"}},
+			"breaking_change": {Type: "string", Description: "Description of the breaking changes, empty if none."},
+		},
+		Required: []string{"title", "summary", "changes", "testing", "breaking_change"},
+	},
+	Fallback: "Respond only with a JSON object, without a code fence, in this format:\n" +
+		`{"title": "...", "summary": "...", "changes": ["..."], "testing": ["..."], "breaking_change": ""}`,
+}
+
+// defaultBase returns the branch of origin's HEAD, or main or master.
+func defaultBase() string {
+	if ref, err := runGit("", "symbolic-ref", "--short", "-q", "refs/remotes/origin/HEAD"); err == nil && strings.TrimSpace(ref) != "" {
+		return strings.TrimSpace(ref)
+	}
+	for _, branch := range []string{"main", "master"} {
+		if _, err := runGit("", "rev-parse", "--verify", "-q", branch); err == nil {
+			return branch
+		}
+	}
+	return "main"
+}
+
+// renderPullRequest renders the body of pr with the configured template.
+func renderPullRequest(pr *pullRequest, cfg PRConfig) (string, error) {
+	text := defaultPRTemplate
+	if cfg.Template != "" {
+		data, err := os.ReadFile(cfg.Template)
+		if err != nil {
+			return "", fmt.Errorf("error reading pr template: %v", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("pr").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("error parsing pr template: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, pr); err != nil {
+		return "", fmt.Errorf("error rendering pr template: %v", err)
+	}
+	return strings.TrimSpace(buf.String()) + "\n", nil
+}
+
+// runPR generates the title and description of a pull request for the
+// commits of the current branch.
+func runPR(args []string) error {
+	fs := flag.NewFlagSet("pr", flag.ExitOnError)
+	base := fs.String("base", "", "branch the pull request is opened against (default pr.base, origin's HEAD, main or master)")
+	output := fs.String("output", "", "write the description to this file, e.g. for gh pr create --body-file")
+	ticketFlag := fs.String("ticket", "", "Jira ticket of the pull request, instead of the ones found in the commit messages")
+	noCache := fs.Bool("no-cache", false, "always call the provider instead of reusing a cached response")
+	fs.Parse(args)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *base == "" {
+		*base = cfg.PR.Base
+	}
+	if *base == "" {
+		*base = defaultBase()
+	}
+	mergeBase, commits, err := branchCommits(*base)
+	if err != nil {
+		return err
+	}
+	provider, err := getProvider()
+	if err != nil {
+		return err
+	}
+
+	raw, err := runGit("", "diff", "--no-color", "--no-ext-diff", mergeBase, "HEAD")
+	if err != nil {
+		return err
+	}
+	diff, err := prepareDiff(cfg, raw, diffSource{Before: mergeBase, After: "HEAD"})
+	if err != nil {
+		return err
+	}
+
+	// The commit messages are sent along with the diff and are redacted the
+	// same way
+	var subjects []string
+	var messages strings.Builder
+	for _, c := range commits {
+		message, hits, err := redactText(c.Message, fmt.Sprintf("commit %.7s", c.SHA), cfg.Redaction)
+		if err != nil {
+			return err
+		}
+		diff.Redactions = append(diff.Redactions, hits...)
+		subjects = append(subjects, c.Subject())
+		messages.WriteString("- " + strings.ReplaceAll(message, "\n", "\n  ") + "\n")
+	}
+	if err := checkPreparedDiff(cfg, diff, true); err != nil {
+		return err
+	}
+
+	tickets := branchTickets(commits, cfg.Commit.TicketKeys)
+	if *ticketFlag != "" {
+		tickets = []string{*ticketFlag}
+	}
+	ticket := ""
+	if len(tickets) > 0 {
+		ticket = tickets[0]
+	}
+	component := resolveComponent(cfg, diff)
+	scope := ticket
+	if component != "" {
+		scope = component
+	}
+
+	prompt := fmt.Sprintf(
+		"Write the title and description of a pull request merging these changes into %s, with these parts:\n"+
+			"- title: a conventional commit style header whose scope is %q\n"+
+			"- summary: what the pull request does and why\n"+
+			"- changes: the notable changes\n"+
+			"- testing: the tests added or changed, and how to verify the change\n"+
+			"- breaking_change: a description of any breaking change, or empty\n\n"+
+			"The commits of the branch are:\n%s\n"+
+			"%s"+
+			"The diff of changes is:\n%s",
+		*base, scope, messages.String(), diffContext(diff), diff.Text,
+	)
+	var withheld []string
+	for _, f := range diff.Withheld {
+		withheld = append(withheld, f.Summary())
+	}
+	req := generationRequest{
+		System:     prSystemPrompt,
+		Prompt:     prompt,
+		Output:     prOutput,
+		NoCache:    *noCache,
+		Redactions: diff.Redactions,
+		Withheld:   withheld,
+		Files:      diff.Files,
+		Ticket:     ticket,
+	}
+	result, err := generateCommitMessage(req, provider)
+	if err != nil {
+		return err
+	}
+
+	pr := &pullRequest{}
+	if result.Provider == ProviderHeuristic {
+		// The heuristic provider describes the diff as a commit message and
+		// reports every detected breaking change
+		msg, err := parseCommitResponse(result.Text)
+		if err != nil {
+			return err
+		}
+		applyBreakingChanges(msg, diff.Breaking)
+		pr.Title, pr.Summary, pr.Changes = msg.Header(), capitalize(msg.Subject)+".", subjects
+		pr.BreakingChange = msg.BreakingChange
+	} else if err := json.Unmarshal([]byte(stripCodeFence(result.Text)), pr); err != nil {
+		return fmt.Errorf("error parsing the pull request description: %v", err)
+	} else if pr.Title == "" {
+		return fmt.Errorf("the pull request description has an empty title")
+	}
+	for _, id := range tickets {
+		link := ticketLink{ID: id}
+		if cfg.PR.TicketURL != "" {
+			link.URL = strings.ReplaceAll(cfg.PR.TicketURL, "{ticket}", id)
+		}
+		pr.Tickets = append(pr.Tickets, link)
+	}
+	pr.Commits, pr.Base = subjects, *base
+
+	body, err := renderPullRequest(pr, cfg.PR)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		if err := os.WriteFile(*output, []byte(body), 0644); err != nil {
+			return fmt.Errorf("error writing %s: %v", *output, err)
+		}
+		fmt.Println(pr.Title)
+		fmt.Fprintf(os.Stderr, "Wrote the description to %s\n", *output)
+	} else {
+		fmt.Printf("%s\n\n%s", pr.Title, body)
+	}
+	printUsageSummary(result)
+	return nil
+}
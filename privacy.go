@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// PrivacyConfig selects which files may be sent to a provider. Files that
+// are not allowed are replaced by a single metadata line in the diff.
+type PrivacyConfig struct {
+	// Include, when set, lists the only paths whose content may be sent
+	Include []string `json:"include,omitempty"`
+	// Exclude lists paths whose content must never be sent
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// ignoreAttribute is the .gitattributes attribute that withholds a file.
+const ignoreAttribute = "commitly-ignore"
+
+// matchGlob matches a slash separated path against a gitignore style glob.
+// "*" and "?" stay within a path segment and "**" spans segments. A pattern
+// without a slash matches any segment, so "*.pem" matches at any depth and
+// "secrets" matches everything below a secrets directory. A trailing slash
+// matches everything below a directory and other patterns are anchored at
+// the repository root.
+func matchGlob(pattern, name string) bool {
+	pattern = strings.TrimPrefix(strings.TrimPrefix(pattern, "./"), "/")
+	segments := strings.Split(name, "/")
+
+	dir := strings.TrimSuffix(pattern, "/")
+	if !strings.Contains(dir, "/") {
+		// Matches a file name or a directory name at any depth
+		for i, segment := range segments {
+			if ok, _ := path.Match(dir, segment); ok && (i < len(segments)-1 || dir == pattern) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	return matchSegments(strings.Split(pattern, "/"), segments)
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func matchesAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithheld reports whether the content of name must not be sent.
+func (c PrivacyConfig) isWithheld(name string) bool {
+	if len(c.Include) > 0 && !matchesAnyGlob(c.Include, name) {
+		return true
+	}
+	return matchesAnyGlob(c.Exclude, name)
+}
+
+// gitIgnoredPaths returns the paths that have the commitly-ignore attribute
+// set in .gitattributes. It fails rather than return no paths when the
+// attributes cannot be read, so that nothing is sent by mistake.
+func gitIgnoredPaths(paths []string) (map[string]bool, error) {
+	ignored := map[string]bool{}
+	if len(paths) == 0 {
+		return ignored, nil
+	}
+	root := getRepoRoot()
+	if root == "" {
+		return nil, fmt.Errorf("error reading the %s attributes: not in a git repository", ignoreAttribute)
+	}
+
+	// Paths are read from stdin, there may be more than fit on a command line
+	cmd := exec.Command("git", "-C", root, "check-attr", "--stdin", "-z", ignoreAttribute)
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\x00") + "\x00")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the %s attributes: %v", ignoreAttribute, err)
+	}
+
+	// The output is a sequence of NUL terminated <path> <attribute> <value>
+	fields := bytes.Split(output, []byte{0})
+	for i := 0; i+2 < len(fields); i += 3 {
+		value := string(fields[i+2])
+		if value != "unspecified" && value != "unset" && value != "false" {
+			ignored[string(fields[i])] = true
+		}
+	}
+	return ignored, nil
+}
+
+// withheldPaths returns the paths whose content must not be sent, because of
+// the privacy config or the commitly-ignore attribute.
+func withheldPaths(cfg PrivacyConfig, paths []string) (map[string]bool, error) {
+	withheld, err := gitIgnoredPaths(paths)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range paths {
+		if cfg.isWithheld(p) {
+			withheld[p] = true
+		}
+	}
+	return withheld, nil
+}
+
+// applyPrivacy replaces the diff of every withheld file with a metadata line
+// and returns the withheld files.
+func applyPrivacy(files []fileDiff, cfg PrivacyConfig) ([]fileDiff, []fileDiff, error) {
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+		if f.OldPath != "" && f.OldPath != f.Path {
+			paths = append(paths, f.OldPath)
+		}
+	}
+	private, err := withheldPaths(cfg, paths)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var kept, withheld []fileDiff
+	for _, f := range files {
+		if private[f.Path] || (f.OldPath != "" && private[f.OldPath]) {
+			withheld = append(withheld, f)
+			f.Text = f.Summary() + " [content withheld by privacy policy]\n"
+		}
+		kept = append(kept, f)
+	}
+	return kept, withheld, nil
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RedactionConfig holds settings for masking secrets in diffs before they
+// are sent to a provider
+type RedactionConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// Strict refuses to send the diff at all when a secret is found
+	Strict bool `json:"strict,omitempty"`
+	// Patterns are extra regular expressions to redact
+	Patterns []string `json:"patterns,omitempty"`
+	// DisableEntropy turns off the high-entropy string detector
+	DisableEntropy bool `json:"disable_entropy,omitempty"`
+}
+
+// setRedactionValue sets a key of the redaction config section.
+func setRedactionValue(redaction *RedactionConfig, key, value string) error {
+	switch key {
+	case "enabled", "strict", "entropy":
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("redaction.%s must be true or false", key)
+		}
+		switch key {
+		case "enabled":
+			redaction.Disabled = !enabled
+		case "strict":
+			redaction.Strict = enabled
+		case "entropy":
+			redaction.DisableEntropy = !enabled
+		}
+	case "patterns":
+		patterns := splitList(value)
+		for _, pattern := range patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("invalid redaction pattern %q: %v", pattern, err)
+			}
+		}
+		redaction.Patterns = patterns
+	default:
+		return fmt.Errorf("unknown key for redaction: %s", key)
+	}
+	return nil
+}
+
+// getRedactionValue returns a key of the redaction config section.
+func getRedactionValue(redaction RedactionConfig, key string) (string, bool) {
+	switch key {
+	case "enabled":
+		return strconv.FormatBool(!redaction.Disabled), true
+	case "strict":
+		return strconv.FormatBool(redaction.Strict), true
+	case "entropy":
+		return strconv.FormatBool(!redaction.DisableEntropy), true
+	case "patterns":
+		return strings.Join(redaction.Patterns, ","), true
+	}
+	return "", false
+}
+
+// secretDetector finds one kind of secret. When Group is set only that
+// submatch is masked, so the surrounding key name stays readable.
+type secretDetector struct {
+	Name    string
+	Pattern *regexp.Regexp
+	Group   int
+	// Files restricts the detector to matching base names
+	Files []string
+}
+
+var builtinDetectors = []secretDetector{
+	{Name: "aws-access-key-id", Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA|AIPA)[0-9A-Z]{16}\b`)},
+	{Name: "aws-secret-access-key", Pattern: regexp.MustCompile(`(?i)aws.{0,20}(?:secret|private).{0,20}?['"]?\s*[:=]\s*['"]?([A-Za-z0-9/+=]{40})\b`), Group: 1},
+	{Name: "jwt", Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{Name: "github-token", Pattern: regexp.MustCompile(`\b(?:ghp|gho|ghu|ghs|ghr|github_pat)_[A-Za-z0-9_]{20,}\b`)},
+	{Name: "slack-token", Pattern: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}\b`)},
+	{Name: "api-key", Pattern: regexp.MustCompile(`\bsk-(?:ant-|proj-)?[A-Za-z0-9_-]{20,}\b`)},
+	{Name: "google-api-key", Pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{Name: "credential-assignment", Pattern: regexp.MustCompile(`(?i)(?:password|passwd|secret|token|api[_-]?key|access[_-]?key)\w*["']?\s*[:=]+\s*["']([^"'\s]{8,})["']`), Group: 1},
+	{Name: "env-secret", Pattern: regexp.MustCompile(`(?i)^\s*(?:export\s+)?\w*(?:password|passwd|secret|token|api_?key|private_?key)\w*\s*=\s*['"]?([^'"\s#]{6,})`), Group: 1,
+		Files: []string{".env", ".env.*", "*.env", "*.properties", "*.ini", "*.cfg", "*.conf"}},
+}
+
+// entropyExempt lists files full of checksums that the entropy detector
+// would otherwise flag on every line.
+var entropyExempt = []string{"go.sum", "package-lock.json", "yarn.lock", "pnpm-lock.yaml", "Cargo.lock", "poetry.lock", "composer.lock", "Gemfile.lock"}
+
+var (
+	privateKeyBegin = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`)
+	privateKeyEnd   = regexp.MustCompile(`-----END [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`)
+	entropyToken    = regexp.MustCompile(`[A-Za-z0-9+/=_-]{20,}`)
+	hexToken        = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+	hunkHeader      = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+)
+
+// redactionHit records one masked secret
+type redactionHit struct {
+	Detector string `json:"detector"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+func (h redactionHit) String() string {
+	return fmt.Sprintf("%s in %s:%d", h.Detector, h.File, h.Line)
+}
+
+// redactDiff masks secrets in the content lines of a unified diff. Both
+// added and removed lines are scanned since both are sent to the provider.
+func redactDiff(diff string, cfg RedactionConfig) (string, []redactionHit, error) {
+	if cfg.Disabled {
+		return diff, nil, nil
+	}
+	detectors, err := redactionDetectors(cfg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var hits []redactionHit
+	var file string
+	var lineNo int
+	inKey, inHeader := false, false
+	lines := strings.Split(diff, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			inKey, inHeader = false, true
+			continue
+		case inHeader && strings.HasPrefix(line, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+			continue
+		case strings.HasPrefix(line, "@@"):
+			inHeader = false
+			if m := hunkHeader.FindStringSubmatch(line); m != nil {
+				lineNo, _ = strconv.Atoi(m[1])
+			}
+			continue
+		case inHeader || line == "" || (line[0] != '+' && line[0] != '-' && line[0] != ' '):
+			continue
+		}
+
+		prefix, content := line[:1], line[1:]
+		current := lineNo
+		if prefix != "-" {
+			lineNo++
+		}
+
+		if masked, begins, ok := maskPrivateKey(content, &inKey); ok {
+			if begins {
+				hits = append(hits, redactionHit{Detector: "private-key", File: file, Line: current})
+			}
+			lines[i] = prefix + masked
+			continue
+		}
+
+		lines[i] = prefix + maskLine(content, file, detectors, cfg, func(detector string) {
+			hits = append(hits, redactionHit{Detector: detector, File: file, Line: current})
+		})
+	}
+
+	return strings.Join(lines, "\n"), hits, nil
+}
+
+// redactText masks secrets in text that is not a diff, such as a summary
+// built from the changed files. Hits are reported against file.
+func redactText(text, file string, cfg RedactionConfig) (string, []redactionHit, error) {
+	if cfg.Disabled {
+		return text, nil, nil
+	}
+	detectors, err := redactionDetectors(cfg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var hits []redactionHit
+	inKey := false
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if masked, begins, ok := maskPrivateKey(line, &inKey); ok {
+			if begins {
+				hits = append(hits, redactionHit{Detector: "private-key", File: file, Line: i + 1})
+			}
+			lines[i] = masked
+			continue
+		}
+		lines[i] = maskLine(line, file, detectors, cfg, func(detector string) {
+			hits = append(hits, redactionHit{Detector: detector, File: file, Line: i + 1})
+		})
+	}
+	return strings.Join(lines, "\n"), hits, nil
+}
+
+// maskPrivateKey masks the key material of a private key block in a line.
+// Blocks span several lines, so inKey carries whether an earlier line opened
+// one. It reports whether the line begins a key and whether it was part of
+// one at all, in which case the other detectors are skipped.
+func maskPrivateKey(content string, inKey *bool) (masked string, begins, ok bool) {
+	if *inKey {
+		if end := privateKeyEnd.FindStringIndex(content); end != nil {
+			*inKey = false
+			if strings.TrimSpace(content[:end[0]]) != "" {
+				return "[REDACTED:private-key]" + content[end[0]:], false, true
+			}
+			return content, false, true
+		}
+		return "[REDACTED:private-key]", false, true
+	}
+	begin := privateKeyBegin.FindStringIndex(content)
+	if begin == nil {
+		return content, false, false
+	}
+	// A key embedded in a single line, as in the JSON key of a service
+	// account, keeps only what surrounds the markers
+	if end := privateKeyEnd.FindStringIndex(content[begin[1]:]); end != nil {
+		return content[:begin[0]] + "[REDACTED:private-key]" + content[begin[1]+end[1]:], true, true
+	}
+	*inKey = true
+	if strings.TrimSpace(content[begin[1]:]) != "" {
+		return content[:begin[1]] + "[REDACTED:private-key]", true, true
+	}
+	return content, true, true
+}
+
+// redactionDetectors returns the built-in detectors and the configured
+// patterns.
+func redactionDetectors(cfg RedactionConfig) ([]secretDetector, error) {
+	detectors := append([]secretDetector(nil), builtinDetectors...)
+	for i, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %v", pattern, err)
+		}
+		detectors = append(detectors, secretDetector{Name: "custom-" + strconv.Itoa(i+1), Pattern: re})
+	}
+	return detectors, nil
+}
+
+// maskLine masks the secrets in a line of file, calling found with the name
+// of the detector for each one.
+func maskLine(content, file string, detectors []secretDetector, cfg RedactionConfig, found func(string)) string {
+	for _, d := range detectors {
+		if len(d.Files) > 0 && !matchesAny(path.Base(file), d.Files) {
+			continue
+		}
+		content = maskMatches(content, d, func() { found(d.Name) })
+	}
+
+	if !cfg.DisableEntropy && !matchesAny(path.Base(file), entropyExempt) {
+		content = entropyToken.ReplaceAllStringFunc(content, func(token string) string {
+			if strings.HasPrefix(token, "[REDACTED") || !isHighEntropy(token) {
+				return token
+			}
+			found("high-entropy-string")
+			return "[REDACTED:high-entropy-string]"
+		})
+	}
+	return content
+}
+
+// maskMatches replaces the matches of d in s, calling found for each one.
+func maskMatches(s string, d secretDetector, found func()) string {
+	matches := d.Pattern.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if d.Group > 0 && len(m) > 2*d.Group+1 && m[2*d.Group] >= 0 {
+			start, end = m[2*d.Group], m[2*d.Group+1]
+		}
+		if start < last || strings.HasPrefix(s[start:end], "[REDACTED") {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString("[REDACTED:" + d.Name + "]")
+		last = end
+		found()
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// isHighEntropy reports whether token looks like a random secret rather than
+// an identifier or a word. Hex strings carry less entropy per character so
+// they get a lower threshold.
+func isHighEntropy(token string) bool {
+	token = strings.TrimRight(token, "=")
+	if hexToken.MatchString(token) {
+		return len(token) >= 32 && shannonEntropy(token) > 3.0
+	}
+	hasDigit := strings.ContainsAny(token, "0123456789")
+	hasUpper := strings.ToLower(token) != token
+	hasLower := strings.ToUpper(token) != token
+	if !hasDigit || !hasUpper || !hasLower {
+		return false
+	}
+	// Short strings cannot reach a high absolute entropy, so the threshold
+	// scales with the length up to 4.5 bits per character
+	threshold := math.Min(4.5, 0.85*math.Log2(float64(len(token))))
+	return shannonEntropy(token) > threshold
+}
+
+func shannonEntropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+	var entropy float64
+	n := float64(len(s))
+	for _, c := range counts {
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// describeHits lists hits for a warning, up to a handful of locations.
+func describeHits(hits []redactionHit) string {
+	const max = 5
+	var parts []string
+	for i, hit := range hits {
+		if i == max {
+			parts = append(parts, fmt.Sprintf("and %d more", len(hits)-max))
+			break
+		}
+		parts = append(parts, hit.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// summarizeHits groups hits by detector, e.g. "jwt (2), aws-access-key-id (1)".
+func summarizeHits(hits []redactionHit) string {
+	counts := map[string]int{}
+	for _, hit := range hits {
+		counts[hit.Detector]++
+	}
+	names := sortedKeys(counts)
+	sort.SliceStable(names, func(i, j int) bool { return counts[names[i]] > counts[names[j]] })
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s (%d)", name, counts[name]))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// semver is a semantic version such as v1.2.3 or v2.0.0-rc.1
+type semver struct {
+	Major, Minor, Patch int
+	Pre                 string
+}
+
+var semverPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// parseSemver parses a version with an optional "v" prefix.
+func parseSemver(s string) (semver, bool) {
+	m := semverPattern.FindStringSubmatch(s)
+	if m == nil {
+		return semver{}, false
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	patch, _ := strconv.Atoi(m[3])
+	return semver{Major: major, Minor: minor, Patch: patch, Pre: m[4]}, true
+}
+
+func (v semver) String() string {
+	s := fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
+
+// Less reports whether v precedes o. Pre-releases precede their release and
+// are compared identifier by identifier as in SemVer 2.0.0, so rc.9 precedes
+// rc.10.
+func (v semver) Less(o semver) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	if v.Patch != o.Patch {
+		return v.Patch < o.Patch
+	}
+	if v.Pre == "" || o.Pre == "" {
+		return v.Pre != "" && o.Pre == ""
+	}
+	return preReleaseLess(strings.Split(v.Pre, "."), strings.Split(o.Pre, "."))
+}
+
+// preReleaseLess compares the dot separated identifiers of two pre-releases.
+// Numeric identifiers compare as numbers and precede alphanumeric ones, and a
+// shorter list precedes a longer one it starts.
+func preReleaseLess(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		an, aErr := strconv.ParseUint(a[i], 10, 64)
+		bn, bErr := strconv.ParseUint(b[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			return an < bn
+		case aErr == nil || bErr == nil:
+			return aErr == nil
+		}
+		return a[i] < b[i]
+	}
+	return len(a) < len(b)
+}
+
+// Bump returns the next version for a major, minor or patch change. A
+// pre-release is released under its own number when it already bumps as much,
+// so v1.3.0-rc.1 becomes v1.3.0 for a feature and v2.0.0 for a breaking change.
+func (v semver) Bump(kind string) semver {
+	release := semver{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	switch kind {
+	case "major":
+		if v.Pre != "" && v.Minor == 0 && v.Patch == 0 {
+			return release
+		}
+		return semver{Major: v.Major + 1}
+	case "minor":
+		if v.Pre != "" && v.Patch == 0 {
+			return release
+		}
+		return semver{Major: v.Major, Minor: v.Minor + 1}
+	}
+	if v.Pre != "" {
+		return release
+	}
+	return semver{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+}
+
+// bumpKind returns the change a release of entries makes: major for breaking
+// changes, minor for features and patch for fixes, or empty when there is
+// nothing to release.
+func bumpKind(entries []changelogEntry) string {
+	kind := ""
+	for _, e := range entries {
+		switch {
+		case e.BreakingChange != "":
+			return "major"
+		case e.Type == "feat":
+			kind = "minor"
+		case kind == "" && (e.Type == "fix" || e.Type == "perf"):
+			kind = "patch"
+		}
+	}
+	return kind
+}
+
+// latestTag returns the highest semver tag with prefix reachable from HEAD.
+func latestTag(prefix string) (string, semver, error) {
+	output, err := runGit("", "tag", "--list", "--merged", "HEAD", prefix+"v*")
+	if err != nil {
+		return "", semver{}, err
+	}
+	tag, latest := "", semver{}
+	for _, name := range strings.Fields(output) {
+		if v, ok := parseSemver(strings.TrimPrefix(name, prefix)); ok && (tag == "" || latest.Less(v)) {
+			tag, latest = name, v
+		}
+	}
+	return tag, latest, nil
+}
+
+// nestedModules returns the directories of the Go modules below the root of
+// the repository.
+func nestedModules() ([]string, error) {
+	output, err := runGit("", "ls-files", "--full-name", ":(top,glob)**/go.mod")
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, name := range strings.Fields(output) {
+		if dir := path.Dir(name); dir != "." {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, nil
+}
+
+// modulePathspecs selects the files of the module in dir, "." for the root,
+// leaving out the modules nested in it.
+func modulePathspecs(dir string, modules []string) []string {
+	specs := []string{":(top)"}
+	if dir != "." {
+		specs[0] += dir
+	}
+	for _, nested := range modules {
+		if nested != dir && (dir == "." || strings.HasPrefix(nested, dir+"/")) {
+			specs = append(specs, ":(top,exclude)"+nested)
+		}
+	}
+	return specs
+}
+
+// runRelease computes the next version from the commits since the last tag
+// and creates an annotated tag with the release notes.
+func runRelease(args []string) error {
+	fs := flag.NewFlagSet("release", flag.ExitOnError)
+	module := fs.String("module", "", "directory of a nested Go module, tagged as <dir>/vX.Y.Z")
+	bump := fs.String("bump", "", "force a major, minor or patch bump instead of computing it")
+	create := fs.Bool("create", false, "create the annotated tag on HEAD")
+	polish := fs.Bool("polish", false, "rewrite the release notes for users with the model")
+	noCache := fs.Bool("no-cache", false, "always call the provider instead of reusing a cached response")
+	fs.Parse(args)
+	if *bump != "" && *bump != "major" && *bump != "minor" && *bump != "patch" {
+		return fmt.Errorf("unknown bump %q, expected major, minor or patch", *bump)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	modules, err := nestedModules()
+	if err != nil {
+		return err
+	}
+	dir, prefix := ".", ""
+	if *module != "" {
+		dir = strings.Trim(path.Clean(*module), "/")
+		if !containsFold(modules, dir) {
+			return fmt.Errorf("no go.mod in %s, modules: %s", dir, strings.Join(modules, ", "))
+		}
+		prefix = dir + "/"
+	}
+
+	tag, current, err := latestTag(prefix)
+	if err != nil {
+		return err
+	}
+	revs := "HEAD"
+	if tag != "" {
+		revs = tag + "..HEAD"
+	}
+	commits, err := listCommits(revs, modulePathspecs(dir, modules)...)
+	if err != nil {
+		return err
+	}
+	since := "the first commit"
+	if tag != "" {
+		since = tag
+	}
+	if len(commits) == 0 {
+		fmt.Printf("No commits since %s, nothing to release.\n", since)
+		return nil
+	}
+
+	kind := *bump
+	if kind == "" {
+		kind = bumpKind(changelogEntries(commits, "", nil))
+		// Before 1.0.0 breaking changes only bump the minor version, 1.0.0
+		// itself is released with --bump major
+		if kind == "major" && current.Major == 0 {
+			kind = "minor"
+		}
+	}
+	if kind == "" {
+		fmt.Printf("The %d commits since %s have no features, fixes or breaking changes, nothing to release.\n"+
+			"Release anyway with --bump patch.\n", len(commits), since)
+		return nil
+	}
+	next := current.Bump(kind)
+	nextTag := prefix + next.String()
+
+	// Go requires a /vN module path from v2 on
+	if next.Major >= 2 {
+		data, _ := os.ReadFile(path.Join(getRepoRoot(), dir, "go.mod"))
+		if module := modulePath(strings.Split(string(data), "\n")); module != "" && moduleMajor(module) != next.Major {
+			fmt.Fprintf(os.Stderr, "Warning: the module path %s must end in /v%d to be released as %s\n", module, next.Major, nextTag)
+		}
+	}
+
+	notes := buildChangelog(commits, nextTag, time.Now().Format("2006-01-02"), cfg.PR.TicketURL, cfg.Commit.TicketKeys, false)
+	text := notes.Markdown()
+	var result *generationResult
+	if *polish {
+		if text, result, err = polishChangelog(cfg, notes, *noCache); err != nil {
+			return err
+		}
+	}
+	message := "Release " + nextTag + "\n\n" + text
+
+	fmt.Printf("Next version: %s (%s bump from %d commits since %s)\n\n", nextTag, kind, len(commits), since)
+	fmt.Print(message)
+	if result != nil {
+		printUsageSummary(result)
+	}
+	if !*create {
+		fmt.Printf("\nCreate the tag with: commitly release --create")
+		if *module != "" {
+			fmt.Printf(" --module %s", dir)
+		}
+		if *bump != "" {
+			fmt.Printf(" --bump %s", *bump)
+		}
+		fmt.Println()
+		return nil
+	}
+	if _, err := runGit(message, "tag", "-a", "--cleanup=verbatim", nextTag, "-F", "-", "HEAD"); err != nil {
+		return err
+	}
+	fmt.Printf("\nCreated tag %s. Push it with: git push origin %s\n", nextTag, nextTag)
+	return nil
+}
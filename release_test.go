@@ -0,0 +1,98 @@
+package main
+
+import "testing"
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		in   string
+		want semver
+		ok   bool
+	}{
+		{"v1.2.3", semver{Major: 1, Minor: 2, Patch: 3}, true},
+		{"1.2.3", semver{Major: 1, Minor: 2, Patch: 3}, true},
+		{"v2.0.0-rc.1", semver{Major: 2, Pre: "rc.1"}, true},
+		{"v1.0.0+build.5", semver{Major: 1}, true},
+		{"v1.2", semver{}, false},
+		{"release-1.2.3", semver{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSemver(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseSemver(%q) = %v, %v, want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSemverLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"v1.2.3", "v1.2.4", true},
+		{"v1.2.4", "v1.2.3", false},
+		{"v1.9.0", "v1.10.0", true},
+		{"v1.10.0", "v2.0.0", true},
+		{"v2.0.0-rc.1", "v2.0.0", true},
+		{"v2.0.0", "v2.0.0-rc.1", false},
+		{"v2.0.0-alpha", "v2.0.0-beta", true},
+		{"v1.0.0-rc.9", "v1.0.0-rc.10", true},
+		{"v1.0.0-rc.10", "v1.0.0-rc.9", false},
+		{"v1.0.0-alpha", "v1.0.0-alpha.1", true},
+		{"v1.0.0-alpha.1", "v1.0.0-alpha.beta", true},
+		{"v1.0.0-beta.11", "v1.0.0-rc.1", true},
+		{"v1.2.3", "v1.2.3", false},
+	}
+	for _, tt := range tests {
+		a, _ := parseSemver(tt.a)
+		b, _ := parseSemver(tt.b)
+		if got := a.Less(b); got != tt.want {
+			t.Errorf("%s.Less(%s) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestSemverBump(t *testing.T) {
+	tests := []struct {
+		from, kind, want string
+	}{
+		{"v1.2.3", "patch", "v1.2.4"},
+		{"v1.2.3", "minor", "v1.3.0"},
+		{"v1.2.3", "major", "v2.0.0"},
+		{"v0.4.1", "major", "v1.0.0"},
+		{"v0.4.1", "minor", "v0.5.0"},
+		{"v0.0.0", "minor", "v0.1.0"},
+		{"v1.2.4-rc.1", "patch", "v1.2.4"},
+		{"v1.3.0-rc.1", "patch", "v1.3.0"},
+		{"v1.3.0-rc.1", "minor", "v1.3.0"},
+		{"v1.3.0-rc.1", "major", "v2.0.0"},
+		{"v1.2.4-rc.1", "minor", "v1.3.0"},
+		{"v2.0.0-rc.1", "major", "v2.0.0"},
+		{"v2.0.0-rc.1", "minor", "v2.0.0"},
+	}
+	for _, tt := range tests {
+		v, _ := parseSemver(tt.from)
+		if got := v.Bump(tt.kind).String(); got != tt.want {
+			t.Errorf("%s.Bump(%q) = %s, want %s", tt.from, tt.kind, got, tt.want)
+		}
+	}
+}
+
+func TestBumpKind(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []changelogEntry
+		want    string
+	}{
+		{"nothing", nil, ""},
+		{"maintenance", []changelogEntry{{Type: "chore"}, {Type: "docs"}}, ""},
+		{"fix", []changelogEntry{{Type: "chore"}, {Type: "fix"}}, "patch"},
+		{"perf", []changelogEntry{{Type: "perf"}}, "patch"},
+		{"feature", []changelogEntry{{Type: "fix"}, {Type: "feat"}, {Type: "fix"}}, "minor"},
+		{"breaking", []changelogEntry{{Type: "feat"}, {Type: "refactor", BreakingChange: "drops the v1 API"}}, "major"},
+	}
+	for _, tt := range tests {
+		if got := bumpKind(tt.entries); got != tt.want {
+			t.Errorf("bumpKind(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
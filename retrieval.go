@@ -0,0 +1,570 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/openai/openai-go"
+	openaiOption "github.com/openai/openai-go/option"
+	googleOption "google.golang.org/api/option"
+)
+
+const (
+	defaultRetrievalTopK = 3
+	// indexMaxCommits is how many recent commits are searched
+	indexMaxCommits    = 2000
+	embeddingBatchSize = 100
+	// indexVersion identifies the entry format; older indexes are rebuilt
+	indexVersion = 2
+	// minCosineSimilarity drops embedded commits that are not really similar
+	minCosineSimilarity = 0.3
+	// minRelativeScore drops commits scoring much lower than the best one,
+	// which usually share a single common word with the change
+	minRelativeScore = 0.5
+)
+
+var retrievalMethods = []string{"bm25", "embeddings"}
+
+// defaultEmbeddingModels are the embedding models of the providers that have
+// an embeddings API
+var defaultEmbeddingModels = map[Provider]string{
+	ProviderOpenAI: "text-embedding-3-small",
+	ProviderGemini: "text-embedding-004",
+}
+
+// RetrievalConfig configures the past commits similar to the current change
+// that are added to the prompt
+type RetrievalConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// Method is "bm25", ranking locally by the changed paths, or "embeddings",
+	// which uses the embeddings API of the OpenAI or Gemini provider and falls
+	// back to bm25. Defaults to bm25.
+	Method string `json:"method,omitempty"`
+	// TopK is the number of similar commits, defaults to 3
+	TopK int `json:"top_k,omitempty"`
+	// EmbeddingModel overrides the embedding model of the provider
+	EmbeddingModel string `json:"embedding_model,omitempty"`
+}
+
+func (r RetrievalConfig) method() string {
+	if r.Method == "" {
+		return "bm25"
+	}
+	return r.Method
+}
+
+func (r RetrievalConfig) topK() int {
+	if r.TopK > 0 {
+		return r.TopK
+	}
+	return defaultRetrievalTopK
+}
+
+// setRetrievalValue sets a key of the retrieval config section.
+func setRetrievalValue(retrieval *RetrievalConfig, key, value string) error {
+	switch key {
+	case "enabled":
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("retrieval.enabled must be true or false")
+		}
+		retrieval.Disabled = !enabled
+	case "method":
+		if value != "" && !containsFold(retrievalMethods, value) {
+			return fmt.Errorf("unknown retrieval method %q, expected one of: %s", value, strings.Join(retrievalMethods, ", "))
+		}
+		retrieval.Method = strings.ToLower(value)
+	case "top_k":
+		if value == "" {
+			retrieval.TopK = 0
+			return nil
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil || v <= 0 || v > 10 {
+			return fmt.Errorf("retrieval.top_k must be an integer between 1 and 10")
+		}
+		retrieval.TopK = v
+	case "embedding_model":
+		retrieval.EmbeddingModel = value
+	default:
+		return fmt.Errorf("unknown key for retrieval: %s", key)
+	}
+	return nil
+}
+
+// getRetrievalValue returns a key of the retrieval config section.
+func getRetrievalValue(retrieval RetrievalConfig, key string) (string, bool) {
+	switch key {
+	case "enabled":
+		return strconv.FormatBool(!retrieval.Disabled), true
+	case "method":
+		return retrieval.method(), true
+	case "top_k":
+		return strconv.Itoa(retrieval.topK()), true
+	case "embedding_model":
+		return retrieval.EmbeddingModel, true
+	}
+	return "", false
+}
+
+// indexEntry is a past commit: the files it changed and its message. The
+// files are kept as git lists them, and the privacy policy is applied when
+// they are retrieved, so that it also covers the commits indexed before it
+// changed.
+type indexEntry struct {
+	Numstat string `json:"numstat"`
+	Message string `json:"message"`
+	// Vector is the embedding of the summary whose hash is VectorOf, if any
+	Vector   []float32 `json:"vector,omitempty"`
+	VectorOf string    `json:"vector_of,omitempty"`
+}
+
+// commitIndex holds the past commits of a repository, by hash
+type commitIndex struct {
+	Version int `json:"version"`
+	// Embedder is the "provider/model" that computed the vectors
+	Embedder string                 `json:"embedder,omitempty"`
+	Entries  map[string]*indexEntry `json:"entries"`
+}
+
+// getIndexPath returns the index of the current repository, in the user
+// cache directory.
+func getIndexPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = filepath.Join(getDataDir(), "cache")
+	}
+	sum := sha256.Sum256([]byte(getRepoRoot()))
+	return filepath.Join(dir, "commitly", "index", hex.EncodeToString(sum[:8])+".json")
+}
+
+// loadIndex reads the index of the current repository, or returns an empty
+// one when there is none or it is outdated.
+func loadIndex() *commitIndex {
+	index := &commitIndex{Version: indexVersion, Entries: map[string]*indexEntry{}}
+	data, err := os.ReadFile(getIndexPath())
+	if err != nil {
+		return index
+	}
+	var stored commitIndex
+	if err := json.Unmarshal(data, &stored); err != nil || stored.Version != indexVersion || stored.Entries == nil {
+		return index
+	}
+	return &stored
+}
+
+func saveIndex(index *commitIndex) error {
+	path := getIndexPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("error creating index directory: %v", err)
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("error serializing index: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("error writing index: %v", err)
+	}
+	return nil
+}
+
+// updateIndex adds the commits reachable from rev that are not indexed yet,
+// and returns the hashes of the commits reachable from rev, which are the
+// ones that may be retrieved.
+func updateIndex(index *commitIndex, rev string) ([]string, error) {
+	output, err := runGit("", "rev-list", "--no-merges", "-n", strconv.Itoa(indexMaxCommits), rev)
+	if err != nil {
+		return nil, err
+	}
+	shas := strings.Fields(output)
+	missing := 0
+	for _, sha := range shas {
+		if index.Entries[sha] == nil {
+			missing++
+		}
+	}
+	if missing > 0 {
+		output, err := runGit("", "log", "--no-merges", "-n", strconv.Itoa(indexMaxCommits), "--no-renames",
+			"--numstat", "--summary", "--format=%x1e%H%x00%B%x00", rev)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range strings.Split(output, "\x1e") {
+			fields := strings.SplitN(record, "\x00", 3)
+			if len(fields) != 3 || index.Entries[fields[0]] != nil {
+				continue
+			}
+			index.Entries[fields[0]] = &indexEntry{
+				Numstat: fields[2],
+				Message: strings.TrimSpace(fields[1]),
+			}
+		}
+	}
+
+	// Forget the commits of old branches once the index grows too large
+	if len(index.Entries) > 2*indexMaxCommits {
+		reachable := map[string]bool{}
+		for _, sha := range shas {
+			reachable[sha] = true
+		}
+		for sha := range index.Entries {
+			if !reachable[sha] {
+				delete(index.Entries, sha)
+			}
+		}
+	}
+	return shas, nil
+}
+
+// tokenize splits text into lowercase words, leaving out numbers and single
+// characters, so "db/migrations/003_add_users.sql" is "db migrations add
+// users sql".
+func tokenize(text string) []string {
+	var tokens []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) > 1 && strings.TrimFunc(word, unicode.IsDigit) != "" {
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+// bm25Scores ranks documents against query with Okapi BM25.
+func bm25Scores(query string, documents []string) []float64 {
+	const k1, b = 1.2, 0.75
+	terms := make([]map[string]int, len(documents))
+	lengths := make([]int, len(documents))
+	frequency := map[string]int{}
+	total := 0
+	for i, doc := range documents {
+		terms[i] = map[string]int{}
+		for _, token := range tokenize(doc) {
+			if terms[i][token] == 0 {
+				frequency[token]++
+			}
+			terms[i][token]++
+			lengths[i]++
+		}
+		total += lengths[i]
+	}
+	scores := make([]float64, len(documents))
+	if total == 0 {
+		return scores
+	}
+	average := float64(total) / float64(len(documents))
+
+	seen := map[string]bool{}
+	for _, token := range tokenize(query) {
+		if seen[token] || frequency[token] == 0 {
+			continue
+		}
+		seen[token] = true
+		n := float64(frequency[token])
+		idf := math.Log(1 + (float64(len(documents))-n+0.5)/(n+0.5))
+		for i := range documents {
+			if tf := float64(terms[i][token]); tf > 0 {
+				scores[i] += idf * tf * (k1 + 1) / (tf + k1*(1-b+b*float64(lengths[i])/average))
+			}
+		}
+	}
+	return scores
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// embedder returns the provider and model computing embeddings, or an error
+// when the configured provider has no embeddings API or the organization
+// policy does not allow it.
+func embedder(cfg *Config) (Provider, ProviderConfig, error) {
+	provider, err := getProvider()
+	if err != nil {
+		return "", ProviderConfig{}, err
+	}
+	actual, pc, section := resolveProvider(cfg, provider)
+	model, ok := defaultEmbeddingModels[actual]
+	if !ok {
+		return "", ProviderConfig{}, fmt.Errorf("the %s provider has no embeddings API", actual)
+	}
+	if cfg.Retrieval.EmbeddingModel != "" {
+		model = cfg.Retrieval.EmbeddingModel
+	}
+	pc.Model = model
+	if err := cfg.Policy.check(actual, section, pc); err != nil {
+		return "", ProviderConfig{}, err
+	}
+	return actual, pc, nil
+}
+
+// embed returns the embeddings of texts, recording the request in the usage
+// and audit logs like any other.
+func embed(cfg *Config, provider Provider, pc ProviderConfig, texts []string) ([][]float32, error) {
+	ctx := context.Background()
+	// Embeddings count towards the budgets and the rate limit, but have no
+	// cheaper model to switch to
+	limits := pc
+	limits.BudgetModel = pc.Model
+	if err := enforceBudget(cfg, provider, string(provider), &limits); err != nil {
+		return nil, err
+	}
+	var vectors [][]float32
+	usage := tokenUsage{}
+	var err error
+	switch provider {
+	case ProviderOpenAI:
+		apiKey := getAPIKey(ProviderOpenAI)
+		if apiKey == "" && pc.BaseURL == "" {
+			return nil, fmt.Errorf("OpenAI API key not found")
+		}
+		options := []openaiOption.RequestOption{openaiOption.WithAPIKey(apiKey)}
+		if pc.BaseURL != "" {
+			options = append(options, openaiOption.WithBaseURL(pc.BaseURL))
+		}
+		var response *openai.CreateEmbeddingResponse
+		response, err = openai.NewClient(options...).Embeddings.New(ctx, openai.EmbeddingNewParams{
+			Input: openai.F[openai.EmbeddingNewParamsInputUnion](openai.EmbeddingNewParamsInputArrayOfStrings(texts)),
+			Model: openai.F(pc.Model),
+		})
+		if err == nil {
+			vectors = make([][]float32, len(texts))
+			for _, e := range response.Data {
+				if int(e.Index) < len(vectors) {
+					vector := make([]float32, len(e.Embedding))
+					for i, v := range e.Embedding {
+						vector[i] = float32(v)
+					}
+					vectors[e.Index] = vector
+				}
+			}
+			usage.InputTokens = int(response.Usage.PromptTokens)
+		} else {
+			err = fmt.Errorf("OpenAI API error: %v", err)
+		}
+	case ProviderGemini:
+		apiKey := getAPIKey(ProviderGemini)
+		if apiKey == "" {
+			return nil, fmt.Errorf("Gemini API key not found")
+		}
+		var client *genai.Client
+		if client, err = genai.NewClient(ctx, googleOption.WithAPIKey(apiKey)); err != nil {
+			return nil, fmt.Errorf("error creating Gemini client: %v", err)
+		}
+		defer client.Close()
+		model := client.EmbeddingModel(pc.Model)
+		batch := model.NewBatch()
+		for _, text := range texts {
+			batch.AddContent(genai.Text(text))
+		}
+		var response *genai.BatchEmbedContentsResponse
+		if response, err = model.BatchEmbedContents(ctx, batch); err == nil {
+			for _, e := range response.Embeddings {
+				vectors = append(vectors, e.Values)
+			}
+		} else {
+			err = fmt.Errorf("Gemini API error: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("the %s provider has no embeddings API", provider)
+	}
+
+	// The summaries left the machine, so they are audited like prompts
+	result := &generationResult{Provider: provider, Model: pc.Model, Usage: usage}
+	if cfg.Audit.Enabled {
+		sent := sentRequest{System: "embeddings", Prompt: strings.Join(texts, "\n\n")}
+		var audited *generationResult
+		if err == nil {
+			audited = result
+		}
+		if auditErr := recordAudit(cfg.Audit, generationRequest{}, sent, provider, pc.Model, audited, err); auditErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not write the audit log: %v\n", auditErr)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(vectors))
+	}
+	result.Cost = estimateCost(cfg, pc.Model, usage)
+	if err := recordUsage(result); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not record usage: %v\n", err)
+	}
+	return vectors, nil
+}
+
+// embeddingScores ranks the candidates by the cosine similarity of their
+// summaries to query, embedding the summaries that have no vector yet or
+// changed since theirs was computed. Summaries in which secrets are found are
+// never sent and score zero.
+func embeddingScores(cfg *Config, index *commitIndex, query string, candidates, summaries []string) ([]float64, error) {
+	provider, pc, err := embedder(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if name := string(provider) + "/" + pc.Model; index.Embedder != name {
+		for _, entry := range index.Entries {
+			entry.Vector, entry.VectorOf = nil, ""
+		}
+		index.Embedder = name
+	}
+
+	var pending []int
+	for i, sha := range candidates {
+		entry := index.Entries[sha]
+		if entry.Vector != nil && entry.VectorOf == summaryHash(summaries[i]) {
+			continue
+		}
+		entry.Vector, entry.VectorOf = nil, ""
+		if _, hits, err := redactText(summaries[i], "index", cfg.Redaction); err != nil {
+			return nil, err
+		} else if len(hits) == 0 {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) > embeddingBatchSize {
+		fmt.Fprintf(os.Stderr, "Indexing %d past commits with %s...\n", len(pending), pc.Model)
+	}
+	for start := 0; start < len(pending); start += embeddingBatchSize {
+		batch := pending[start:min(start+embeddingBatchSize, len(pending))]
+		texts := make([]string, len(batch))
+		for i, candidate := range batch {
+			texts[i] = summaries[candidate]
+		}
+		vectors, err := embed(cfg, provider, pc, texts)
+		if err != nil {
+			return nil, err
+		}
+		for i, candidate := range batch {
+			entry := index.Entries[candidates[candidate]]
+			entry.Vector, entry.VectorOf = vectors[i], summaryHash(texts[i])
+		}
+	}
+
+	vectors, err := embed(cfg, provider, pc, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	scores := make([]float64, len(candidates))
+	for i, sha := range candidates {
+		if similarity := cosineSimilarity(vectors[0], index.Entries[sha].Vector); similarity >= minCosineSimilarity {
+			scores[i] = similarity
+		}
+	}
+	return scores, nil
+}
+
+// summaryHash identifies the summary an embedding was computed from.
+func summaryHash(summary string) string {
+	sum := sha256.Sum256([]byte(summary))
+	return hex.EncodeToString(sum[:8])
+}
+
+// similarCommits returns the past commits whose changed files are most
+// similar to files, as pairs of changed files and message. rev is the commit
+// the generated one follows, "" for HEAD; only its ancestors are searched.
+// Commits whose message contains a secret are skipped.
+func similarCommits(cfg *Config, files []fileDiff, rev string) (string, error) {
+	if cfg.Retrieval.Disabled || len(files) == 0 {
+		return "", nil
+	}
+	if rev == "" {
+		rev = "HEAD"
+	}
+	if _, err := runGit("", "rev-parse", "--verify", "-q", rev); err != nil {
+		return "", nil
+	}
+
+	index := loadIndex()
+	shas, err := updateIndex(index, rev)
+	if err != nil {
+		return "", err
+	}
+	paths := map[string]bool{}
+	for _, sha := range shas {
+		if entry := index.Entries[sha]; entry != nil {
+			for _, p := range numstatPaths(entry.Numstat) {
+				paths[p] = true
+			}
+		}
+	}
+	withheld, err := withheldPaths(cfg.Privacy, sortedKeys(paths))
+	if err != nil {
+		return "", err
+	}
+	var candidates, documents []string
+	for _, sha := range shas {
+		if entry := index.Entries[sha]; entry != nil {
+			if summary := summarizeNumstat(entry.Numstat, withheld); summary != "" {
+				candidates = append(candidates, sha)
+				documents = append(documents, summary)
+			}
+		}
+	}
+	query := summarizeFiles(files, 0)
+
+	var scores []float64
+	if cfg.Retrieval.method() == "embeddings" {
+		if scores, err = embeddingScores(cfg, index, query, candidates, documents); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not use embeddings, ranking similar commits with bm25: %v\n", err)
+		}
+	}
+	if scores == nil {
+		scores = bm25Scores(query, documents)
+	}
+	if err := saveIndex(index); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not save the commit index: %v\n", err)
+	}
+
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	// Ties go to the newest commit, which comes first
+	sort.SliceStable(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+
+	var similar []string
+	for _, i := range order {
+		if len(similar) == cfg.Retrieval.topK() || scores[i] <= 0 || scores[i] < minRelativeScore*scores[order[0]] {
+			break
+		}
+		entry := index.Entries[candidates[i]]
+		lines := strings.Split(entry.Message, "\n")
+		if len(lines) > exampleMaxBodyLines+2 {
+			lines = append(lines[:exampleMaxBodyLines+2], "...")
+		}
+		text := fmt.Sprintf("Changed files:\n%s\nMessage:\n%s", documents[i], strings.Join(lines, "\n"))
+		if _, hits, err := redactText(text, "history", cfg.Redaction); err != nil {
+			return "", err
+		} else if len(hits) > 0 {
+			continue
+		}
+		similar = append(similar, fmt.Sprintf("Similar change %d\n%s", len(similar)+1, text))
+	}
+	return strings.Join(similar, "\n\n"), nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// commitInfo is what rewriting a commit preserves
+type commitInfo struct {
+	SHA            string
+	Tree           string
+	Parents        []string
+	AuthorName     string
+	AuthorEmail    string
+	AuthorDate     string
+	CommitterName  string
+	CommitterEmail string
+	CommitterDate  string
+	Message        string
+}
+
+// Subject returns the first line of the message.
+func (c commitInfo) Subject() string {
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	return subject
+}
+
+// readCommit returns the metadata of rev. Dates are kept in git's raw format
+// so they are restored exactly.
+func readCommit(rev string) (commitInfo, error) {
+	output, err := runGit("", "log", "-1", "--date=raw", "--format=%H%x00%T%x00%P%x00%an%x00%ae%x00%ad%x00%cn%x00%ce%x00%cd%x00%B", rev)
+	if err != nil {
+		return commitInfo{}, err
+	}
+	fields := strings.SplitN(output, "\x00", 10)
+	if len(fields) != 10 {
+		return commitInfo{}, fmt.Errorf("unexpected output of git log for %s", rev)
+	}
+	return commitInfo{
+		SHA:            fields[0],
+		Tree:           fields[1],
+		Parents:        strings.Fields(fields[2]),
+		AuthorName:     fields[3],
+		AuthorEmail:    fields[4],
+		AuthorDate:     fields[5],
+		CommitterName:  fields[6],
+		CommitterEmail: fields[7],
+		CommitterDate:  fields[8],
+		Message:        strings.TrimRight(fields[9], "\n"),
+	}, nil
+}
+
+// commitTree creates a commit with the tree, author, committer and dates of c
+// on top of parent, and returns its hash.
+func commitTree(c commitInfo, parent, message string) (string, error) {
+	args := []string{"commit-tree", c.Tree, "-F", "-"}
+	if parent != "" {
+		args = append(args, "-p", parent)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Stdin = strings.NewReader(message + "\n")
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME="+c.AuthorName, "GIT_AUTHOR_EMAIL="+c.AuthorEmail, "GIT_AUTHOR_DATE="+c.AuthorDate,
+		"GIT_COMMITTER_NAME="+c.CommitterName, "GIT_COMMITTER_EMAIL="+c.CommitterEmail, "GIT_COMMITTER_DATE="+c.CommitterDate,
+	)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error executing 'git commit-tree' for %s: %v", c.SHA, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ticketPattern finds a Jira ticket such as ABC-123 in a commit message.
+// Project keys start with at least two letters.
+var ticketPattern = regexp.MustCompile(`\b[A-Z]{2}[A-Z0-9_]*-\d+\b`)
+
+// notTicketKeys are prefixes of standards and algorithms that read like
+// tickets, as in UTF-8, SHA-256 or ISO-8601.
+var notTicketKeys = map[string]bool{
+	"UTF": true, "UCS": true, "SHA": true, "MD": true, "AES": true, "DES": true, "RSA": true, "ECDSA": true,
+	"ISO": true, "IEC": true, "IEEE": true, "ANSI": true, "ECMA": true, "RFC": true, "PEP": true, "CVE": true,
+	"CWE": true, "GHSA": true, "TLS": true, "SSL": true, "HTTP": true, "PKCS": true, "GPL": true, "LGPL": true,
+	"AGPL": true, "MPL": true, "BSD": true, "CC": true, "WCAG": true,
+}
+
+// findTickets returns the Jira tickets mentioned in text, in order. When
+// keys lists the project keys, only tickets of those projects are found.
+func findTickets(text string, keys []string) []string {
+	var tickets []string
+	for _, id := range ticketPattern.FindAllString(text, -1) {
+		key := id[:strings.LastIndex(id, "-")]
+		if len(keys) > 0 && !containsFold(keys, key) || len(keys) == 0 && notTicketKeys[key] {
+			continue
+		}
+		tickets = append(tickets, id)
+	}
+	return tickets
+}
+
+// findTicket returns the first ticket of text, or "" if there is none.
+func findTicket(text string, keys []string) string {
+	if tickets := findTickets(text, keys); len(tickets) > 0 {
+		return tickets[0]
+	}
+	return ""
+}
+
+// rewriteChain returns the commits from the oldest commit of revs up to HEAD,
+// oldest first, which are the commits rewriting revs replaces. The history
+// must be linear and contain every commit of revs.
+func rewriteChain(revs []string) ([]commitInfo, error) {
+	oldest := revs[0]
+	output, err := runGit("", "rev-list", "--reverse", "--ancestry-path", oldest+"..HEAD")
+	if err != nil {
+		return nil, err
+	}
+	shas := append([]string{oldest}, strings.Fields(output)...)
+
+	inChain := map[string]bool{}
+	var chain []commitInfo
+	for _, sha := range shas {
+		c, err := readCommit(sha)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.Parents) > 1 {
+			return nil, fmt.Errorf("cannot rewrite merge commit %.7s %s, only linear history is supported", c.SHA, c.Subject())
+		}
+		inChain[c.SHA] = true
+		chain = append(chain, c)
+	}
+	for _, sha := range revs {
+		if !inChain[sha] {
+			return nil, fmt.Errorf("commit %.7s is not an ancestor of HEAD", sha)
+		}
+	}
+	return chain, nil
+}
+
+// rewriteHistory recreates chain with the messages given by hash, keeping
+// the other messages, and moves the current branch to the new tip. Trees are
+// unchanged, so the index and working tree are left alone.
+func rewriteHistory(chain []commitInfo, messages map[string]string) (string, error) {
+	head, err := runGit("", "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	head = strings.TrimSpace(head)
+	if head != chain[len(chain)-1].SHA {
+		return "", fmt.Errorf("HEAD moved while the messages were generated")
+	}
+
+	parent := ""
+	if len(chain[0].Parents) > 0 {
+		parent = chain[0].Parents[0]
+	}
+	for _, c := range chain {
+		message, ok := messages[c.SHA]
+		if !ok {
+			message = c.Message
+		}
+		if parent, err = commitTree(c, parent, message); err != nil {
+			return "", err
+		}
+	}
+	if _, err := runGit("", "update-ref", "-m", "commitly reword", "HEAD", parent, head); err != nil {
+		return "", err
+	}
+	return parent, nil
+}
+
+// revisionList returns the commits of a range, oldest first. A single
+// revision such as "main" means the commits since it, "main..HEAD".
+func revisionList(spec string) ([]string, error) {
+	if !strings.Contains(spec, "..") {
+		spec += "..HEAD"
+	}
+	output, err := runGit("", "rev-list", "--reverse", spec)
+	if err != nil {
+		return nil, err
+	}
+	revs := strings.Fields(output)
+	if len(revs) == 0 {
+		return nil, fmt.Errorf("no commits in %s", spec)
+	}
+	return revs, nil
+}
+
+// runReword generates a new message for every commit of a range from its own
+// diff and rewrites the commits once approved.
+func runReword(args []string) error {
+	fs := flag.NewFlagSet("reword", flag.ExitOnError)
+	ticketFlag := fs.String("ticket", "", "Jira ticket of every commit, instead of the one found in each message")
+	yes := fs.Bool("yes", false, "rewrite the commits without asking")
+	noCache := fs.Bool("no-cache", false, "always call the provider instead of reusing a cached response")
+	fs.Usage = func() {
+		fmt.Println("Usage: commitly reword [flags] <range>")
+		fmt.Println("Example: commitly reword main..HEAD")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	revs, err := revisionList(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	chain, err := rewriteChain(revs)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	provider, err := getProvider()
+	if err != nil {
+		return err
+	}
+	reword := map[string]bool{}
+	for _, sha := range revs {
+		reword[sha] = true
+	}
+	messages := map[string]string{}
+	for _, c := range chain {
+		if !reword[c.SHA] {
+			continue
+		}
+		raw, err := runGit("", "show", "--format=", "--no-color", "--no-ext-diff", c.SHA)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\n%.7s %s\n", c.SHA, c.Subject())
+		diff, err := prepareDiff(cfg, raw, diffSource{Before: c.SHA + "^", After: c.SHA})
+		if err != nil {
+			return err
+		}
+		if err := checkPreparedDiff(cfg, diff, true); err != nil {
+			return err
+		}
+
+		ticket := *ticketFlag
+		if ticket == "" {
+			ticket = findTicket(c.Message, cfg.Commit.TicketKeys)
+		}
+		// Only the history before the commit is shown, not the commit itself
+		history := ""
+		if len(c.Parents) > 0 {
+			if history, err = promptHistory(cfg, diff.Files, c.Parents[0]); err != nil {
+				return err
+			}
+		}
+		component := resolveComponent(cfg, diff)
+		req := commitRequest(diff, ticket, component, history)
+		req.NoCache = *noCache
+		result, err := generateCommitMessage(req, provider)
+		if err != nil {
+			return err
+		}
+		_, message, err := finishCommitMessage(cfg, result, diff, ticket, component)
+		if err != nil {
+			return err
+		}
+		messages[c.SHA] = message
+
+		for _, line := range strings.Split(message, "\n") {
+			fmt.Println(strings.TrimRight("    "+line, " "))
+		}
+		printUsageSummary(result)
+	}
+	fmt.Println()
+
+	if !*yes {
+		fmt.Printf("Rewrite %d commits? [y/N] ", len(messages))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Println("Nothing was rewritten.")
+			return nil
+		}
+	}
+	head, err := rewriteHistory(chain, messages)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Rewrote %d commits, HEAD is now %.7s. The previous HEAD is %.7s in the reflog.\n",
+		len(chain), head, chain[len(chain)-1].SHA)
+	return nil
+}
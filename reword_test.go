@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindTickets(t *testing.T) {
+	tests := []struct {
+		text string
+		keys []string
+		want []string
+	}{
+		{"feat(ABC-12): add login\n\nRefs: OPS-3", nil, []string{"ABC-12", "OPS-3"}},
+		{"fix: decode UTF-8 names and hash them with SHA-256", nil, nil},
+		{"fix(ABC-1): parse ISO-8601 dates, see RFC-3339 and CVE-2024-1234", nil, []string{"ABC-1"}},
+		{"chore: encrypt with AES-128 for A1-2", nil, nil},
+		{"fix(DATA_LAKE-7): drop X9-1", nil, []string{"DATA_LAKE-7"}},
+		{"feat(ABC-12): add login for OPS-3 and SHA-256", []string{"OPS", "SHA"}, []string{"OPS-3", "SHA-256"}},
+	}
+	for _, tt := range tests {
+		if got := findTickets(tt.text, tt.keys); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("findTickets(%q, %v) = %v, want %v", tt.text, tt.keys, got, tt.want)
+		}
+	}
+	if got := findTicket("docs: UTF-8 only, see ABC-4", nil); got != "ABC-4" {
+		t.Errorf("findTicket() = %q, want ABC-4", got)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ScopeConfig maps changed paths to components, whose name becomes the scope
+// of the commit instead of the ticket
+type ScopeConfig struct {
+	// Sources are tried in order for every file: "config" for Components,
+	// "codeowners" for CODEOWNERS sections and owners, and "go" for Go module
+	// and package boundaries. Only "config" is used when empty.
+	Sources []string `json:"sources,omitempty"`
+	// Components maps a component name to the path globs it owns
+	Components map[string][]string `json:"components,omitempty"`
+}
+
+var scopeSources = []string{"config", "codeowners", "go"}
+
+// setScopeValue sets a key of the scope config section.
+func setScopeValue(scope *ScopeConfig, key, value string) error {
+	switch {
+	case key == "sources":
+		sources := splitList(value)
+		for _, source := range sources {
+			if !containsFold(scopeSources, source) {
+				return fmt.Errorf("unknown scope source %q, expected one of: %s", source, strings.Join(scopeSources, ", "))
+			}
+		}
+		scope.Sources = sources
+	case strings.HasPrefix(key, "components."):
+		name := strings.TrimPrefix(key, "components.")
+		if name == "" {
+			return fmt.Errorf("missing component name, expected scope.components.<name>")
+		}
+		if value == "" {
+			delete(scope.Components, name)
+			return nil
+		}
+		if scope.Components == nil {
+			scope.Components = map[string][]string{}
+		}
+		scope.Components[name] = splitList(value)
+	default:
+		return fmt.Errorf("unknown key for scope: %s", key)
+	}
+	return nil
+}
+
+// getScopeValue returns a key of the scope config section.
+func getScopeValue(scope ScopeConfig, key string) (string, bool) {
+	switch {
+	case key == "sources":
+		return strings.Join(scope.Sources, ","), true
+	case strings.HasPrefix(key, "components."):
+		return strings.Join(scope.Components[strings.TrimPrefix(key, "components.")], ","), true
+	}
+	return "", false
+}
+
+// codeownersRule assigns the paths matching Pattern to a component
+type codeownersRule struct {
+	Pattern   string
+	Component string
+}
+
+// loadCodeowners reads the CODEOWNERS file of the repository. Rules in a
+// GitLab style "[Section]" belong to the section, other rules to their first
+// owner without the "@" and organization, e.g. "@acme/billing" is "billing".
+func loadCodeowners(root string) []codeownersRule {
+	for _, name := range []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS", ".gitlab/CODEOWNERS"} {
+		file, err := os.Open(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			continue
+		}
+		defer file.Close()
+
+		var rules []codeownersRule
+		section := ""
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if header := strings.TrimPrefix(line, "^"); strings.HasPrefix(header, "[") {
+				if end := strings.Index(header, "]"); end > 0 {
+					section = strings.TrimSpace(header[1:end])
+				}
+				continue
+			}
+			fields := strings.Fields(line)
+			component := section
+			if component == "" && len(fields) > 1 {
+				owner := strings.TrimPrefix(fields[1], "@")
+				owner = owner[strings.LastIndex(owner, "/")+1:]
+				if at := strings.Index(owner, "@"); at > 0 {
+					owner = owner[:at]
+				}
+				component = owner
+			}
+			if component != "" {
+				rules = append(rules, codeownersRule{Pattern: fields[0], Component: component})
+			}
+		}
+		return rules
+	}
+	return nil
+}
+
+// goComponent returns the Go module or package that name belongs to. Nested
+// modules are named after the last element of their module path, and
+// packages of the root module after their directory.
+func goComponent(root, name string, modules map[string]string) string {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		module, ok := modules[dir]
+		if !ok {
+			data, _ := os.ReadFile(filepath.Join(root, filepath.FromSlash(dir), "go.mod"))
+			module = modulePath(strings.Split(string(data), "\n"))
+			modules[dir] = module
+		}
+		if module != "" {
+			module = majorVersionSuffix.ReplaceAllString(module, "")
+			return module[strings.LastIndex(module, "/")+1:]
+		}
+	}
+	if path.Ext(name) != ".go" {
+		return ""
+	}
+	return inferDirectoryScope([]fileDiff{{Path: name}})
+}
+
+// resolveScope maps every changed file to a component and returns the
+// dominant one, the component with the most changed lines, along with all
+// the components touched, most changed first. Files that belong to no
+// component are ignored.
+func resolveScope(cfg ScopeConfig, files []fileDiff) (string, []string) {
+	sources := cfg.Sources
+	if len(sources) == 0 {
+		sources = []string{"config"}
+	}
+	root := getRepoRoot()
+	var rules []codeownersRule
+	if containsFold(sources, "codeowners") {
+		rules = loadCodeowners(root)
+	}
+	modules := map[string]string{}
+
+	weights := map[string]int{}
+	for _, f := range files {
+		component := ""
+		for _, source := range sources {
+			switch strings.ToLower(source) {
+			case "config":
+				for _, name := range sortedKeys(cfg.Components) {
+					if matchesAnyGlob(cfg.Components[name], f.Path) {
+						component = name
+						break
+					}
+				}
+			case "codeowners":
+				// The last matching rule wins, as it does for owners
+				for _, rule := range rules {
+					if matchGlob(rule.Pattern, f.Path) {
+						component = rule.Component
+					}
+				}
+			case "go":
+				component = goComponent(root, f.Path, modules)
+			}
+			if component != "" {
+				break
+			}
+		}
+		if component != "" {
+			weights[component] += f.Added + f.Removed + 1
+		}
+	}
+
+	components := sortedKeys(weights)
+	sort.SliceStable(components, func(i, j int) bool {
+		return weights[components[i]] > weights[components[j]]
+	})
+	if len(components) == 0 {
+		return "", nil
+	}
+	return components[0], components
+}
+
+// applyScope makes the component the scope of msg and moves the ticket to a
+// "Refs:" footer.
+func applyScope(msg *CommitMessage, component, ticket string) {
+	if component == "" {
+		return
+	}
+	msg.Scope = component
+	if ticket == "" {
+		return
+	}
+	for _, footer := range msg.Footers {
+		if strings.Contains(footer, ticket) {
+			return
+		}
+	}
+	msg.Footers = append(msg.Footers, "Refs: "+ticket)
+}
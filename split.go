@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// runGit runs git with input on stdin and returns its output. The error
+// includes what git printed.
+func runGit(input string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("error executing 'git %s': %v, output: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// splitHunk is the smallest part of the staged diff that can be committed on
+// its own: a single hunk of a modified file, or a whole file when it is
+// added, deleted, renamed, binary or changes mode
+type splitHunk struct {
+	// ID numbers the hunks from 1 in the prompt
+	ID   int
+	File fileDiff
+	// Header is the file header of the diff, repeated in every patch
+	Header string
+	// Text is the hunk, empty when the header holds the whole file
+	Text string
+	// Index and Count place the hunk among the hunks of its file
+	Index int
+	Count int
+}
+
+// Label describes the hunk, e.g. "modified main.go (hunk 2 of 3)".
+func (h splitHunk) Label() string {
+	if h.Count <= 1 {
+		return h.File.Summary()
+	}
+	return fmt.Sprintf("%s %s (hunk %d of %d)", h.File.Status, h.File.Path, h.Index, h.Count)
+}
+
+// splitHunks breaks the files of a diff into hunks.
+func splitHunks(files []fileDiff) []splitHunk {
+	var hunks []splitHunk
+	for _, f := range files {
+		start := strings.Index(f.Text, "\n@@")
+		if f.Status != "modified" || f.Binary || start < 0 || strings.Contains(f.Text[:start], "\nold mode ") {
+			hunks = append(hunks, splitHunk{ID: len(hunks) + 1, File: f, Header: f.Text, Index: 1, Count: 1})
+			continue
+		}
+
+		header, body := f.Text[:start+1], f.Text[start+1:]
+		var parts []string
+		for _, part := range strings.SplitAfter(body, "\n") {
+			if strings.HasPrefix(part, "@@") || len(parts) == 0 {
+				parts = append(parts, part)
+			} else {
+				parts[len(parts)-1] += part
+			}
+		}
+		for i, part := range parts {
+			hunks = append(hunks, splitHunk{ID: len(hunks) + 1, File: f, Header: header, Text: part, Index: i + 1, Count: len(parts)})
+		}
+	}
+	return hunks
+}
+
+// splitGroup is a proposed commit made of some of the hunks
+type splitGroup struct {
+	Hunks   []splitHunk
+	Message *CommitMessage
+}
+
+// Patch returns the diff of the group, with every file header written once
+// and the hunks in their original order.
+func (g splitGroup) Patch(all []splitHunk) string {
+	selected := map[int]bool{}
+	for _, h := range g.Hunks {
+		selected[h.ID] = true
+	}
+	var b strings.Builder
+	header := ""
+	for _, h := range all {
+		if !selected[h.ID] {
+			continue
+		}
+		if h.Header != header {
+			header = h.Header
+			b.WriteString(header)
+		}
+		b.WriteString(h.Text)
+	}
+	return b.String()
+}
+
+// clusterKey groups files for the heuristic split: dependency files and
+// documentation together, everything else by directory, i.e. by package.
+func clusterKey(f fileDiff) string {
+	switch {
+	case dependencyFilePattern.MatchString(f.Path):
+		return "dependencies"
+	case docFilePattern.MatchString(f.Path):
+		return "docs"
+	}
+	return path.Dir(f.Path)
+}
+
+// clusterHunks groups whole files by clusterKey and describes each group with
+// the heuristic provider.
+func clusterHunks(hunks []splitHunk, ticket string) []splitGroup {
+	var keys []string
+	byKey := map[string][]splitHunk{}
+	for _, h := range hunks {
+		key := clusterKey(h.File)
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], h)
+	}
+
+	var groups []splitGroup
+	for _, key := range keys {
+		group := splitGroup{Hunks: byKey[key]}
+		group.Message = heuristicCommitMessage(parseDiff(group.Patch(hunks)), ticket)
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+const splitSystemPrompt = "You split staged changes into small, atomic commits. Each commit holds one coherent change, " +
+	"such as a feature, a fix, a refactoring or a dependency update, and gets a conventional commit message. " +
+	"Types are limited to: feat, fix, docs, style, refactor, test, chore."
+
+var splitOutput = &structuredOutput{
+	Name:        "split_commits",
+	Description: "The staged hunks grouped into commits, in the order they should be made.",
+	Schema: &schema{
+		Type: "object",
+		Properties: map[string]*schema{
+			"commits": {Type: "array", Description: "The commits, each with its hunks and message.", Items: &schema{
+				Type: "object",
+				Properties: map[string]*schema{
+					"hunks":           {Type: "array", Description: "Numbers of the hunks in the commit.", Items: &schema{Type: "integer"}},
+					"type":            commitMessageOutput.Schema.Properties["type"],
+					"scope":           commitMessageOutput.Schema.Properties["scope"],
+					"subject":         commitMessageOutput.Schema.Properties["subject"],
+					"body":            commitMessageOutput.Schema.Properties["body"],
+					"breaking_change": commitMessageOutput.Schema.Properties["breaking_change"],
+					"footers":         commitMessageOutput.Schema.Properties["footers"],
+				},
+				Required: []string{"hunks", "type", "scope", "subject", "body", "breaking_change", "footers"},
+			}},
+		},
+		Required: []string{"commits"},
+	},
+	Fallback: "Respond only with a JSON object, without a code fence, in this format:\n" +
+		`{"commits": [{"hunks": [1, 2], "type": "feat", "scope": "", "subject": "...", "body": ["..."], "breaking_change": "", "footers": []}]}`,
+}
+
+// proposedCommit is a commit of the split response
+type proposedCommit struct {
+	Hunks []int `json:"hunks"`
+	CommitMessage
+}
+
+// parseSplitResponse turns the model reply into groups. Hunks that are
+// unknown or already used are dropped, and hunks the model left out are
+// clustered by the heuristic into extra groups.
+func parseSplitResponse(raw string, hunks []splitHunk, ticket string) ([]splitGroup, error) {
+	var response struct {
+		Commits []proposedCommit `json:"commits"`
+	}
+	if err := json.Unmarshal([]byte(stripCodeFence(raw)), &response); err != nil {
+		return nil, fmt.Errorf("error parsing the proposed commits: %v", err)
+	}
+
+	used := map[int]bool{}
+	var groups []splitGroup
+	for _, commit := range response.Commits {
+		var group splitGroup
+		for _, id := range commit.Hunks {
+			if id >= 1 && id <= len(hunks) && !used[id] {
+				used[id] = true
+				group.Hunks = append(group.Hunks, hunks[id-1])
+			}
+		}
+		if len(group.Hunks) > 0 {
+			msg := commit.CommitMessage
+			group.Message = &msg
+			groups = append(groups, group)
+		}
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no commits were proposed")
+	}
+
+	var missing []splitHunk
+	for _, h := range hunks {
+		if !used[h.ID] {
+			missing = append(missing, h)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %d hunks were not assigned to a commit, grouping them by directory\n", len(missing))
+		groups = append(groups, clusterHunks(missing, ticket)...)
+	}
+	return groups, nil
+}
+
+// splitRequest builds the request asking the model to group the hunks. The
+// hunks go through the same privacy, truncation and redaction steps as the
+// diff of a single commit.
+func splitRequest(cfg *Config, hunks []splitHunk, files []fileDiff, ticket string, noCache bool) (generationRequest, error) {
+	_, withheldFiles, err := applyPrivacy(files, cfg.Privacy)
+	if err != nil {
+		return generationRequest{}, err
+	}
+	private := map[string]bool{}
+	var withheld []string
+	for _, f := range withheldFiles {
+		private[f.Path] = true
+		withheld = append(withheld, f.Summary())
+	}
+
+	var b strings.Builder
+	var hits []redactionHit
+	for _, h := range hunks {
+		fmt.Fprintf(&b, "Hunk %d: %s\n", h.ID, h.Label())
+		if private[h.File.Path] {
+			b.WriteString("[content withheld by privacy policy]\n\n")
+			continue
+		}
+		// The hunk is redacted as a diff under the header of its file, so
+		// detectors see the content without the +/- prefixes, know the file
+		// name and line numbers, and follow private keys across lines
+		header, text := "", h.Header
+		if h.Text != "" {
+			header, text = h.Header, h.Text
+		}
+		text = strings.TrimSuffix(text, "\n")
+		truncated, _ := truncateFile(fileDiff{Text: text + "\n"}, cfg.Diff.MaxFileLines)
+		redacted, found, err := redactDiff(header+truncated.Text, cfg.Redaction)
+		if err != nil {
+			return generationRequest{}, err
+		}
+		hits = append(hits, found...)
+		b.WriteString(strings.TrimPrefix(redacted, header) + "\n")
+	}
+	if len(hits) > 0 {
+		if cfg.Redaction.Strict {
+			return generationRequest{}, fmt.Errorf("refusing to send the diff, found %d potential secrets: %s\n"+
+				"Unstage them or disable strict mode with: commitly config set redaction.strict false",
+				len(hits), describeHits(hits))
+		}
+		fmt.Fprintf(os.Stderr, "Warning: redacted %d potential secrets before sending the diff: %s\n",
+			len(hits), describeHits(hits))
+	}
+
+	var public []fileDiff
+	for _, f := range files {
+		if !private[f.Path] {
+			public = append(public, f)
+		}
+	}
+	history, err := promptHistory(cfg, public, "")
+	if err != nil {
+		return generationRequest{}, err
+	}
+	prompt := fmt.Sprintf(
+		"Group these staged hunks into atomic commits for Jira ticket '%s'. Every hunk must be in exactly one commit. "+
+			"Hunks of the same file may go to different commits when they are unrelated. Order the commits so that "+
+			"each one builds on the previous ones, and give each one a message whose scope is the ticket (%s).\n\n"+
+			"The hunks are:\n%s"+
+			"%s",
+		ticket, ticket, strings.TrimSuffix(b.String(), "\n"), history,
+	)
+	return generationRequest{
+		System:     splitSystemPrompt,
+		Prompt:     prompt,
+		Output:     splitOutput,
+		NoCache:    noCache,
+		Redactions: hits,
+		Withheld:   withheld,
+		Files:      files,
+		Ticket:     ticket,
+	}, nil
+}
+
+// commitGroups commits every group in order, staging each one with
+// "git apply --cached". The working tree is never touched. When anything
+// fails, HEAD and the index are restored to where they were.
+func commitGroups(groups []splitGroup, hunks []splitHunk, messages []string) error {
+	head, err := runGit("", "rev-parse", "--verify", "-q", "HEAD")
+	if err != nil {
+		return fmt.Errorf("split needs an existing commit to start from")
+	}
+	head = strings.TrimSpace(head)
+	index, err := runGit("", "write-tree")
+	if err != nil {
+		return err
+	}
+	index = strings.TrimSpace(index)
+
+	rollback := func(cause error) error {
+		_, resetErr := runGit("", "reset", "-q", "--soft", head)
+		_, readErr := runGit("", "read-tree", index)
+		if resetErr != nil || readErr != nil {
+			return fmt.Errorf("%v\nrestoring the repository also failed, reset it with: git reset --soft %s && git read-tree %s",
+				cause, head, index)
+		}
+		return fmt.Errorf("%v\nrestored HEAD and the index to their state before the split", cause)
+	}
+
+	if _, err := runGit("", "reset", "-q"); err != nil {
+		return rollback(err)
+	}
+	for i, group := range groups {
+		if _, err := runGit(group.Patch(hunks), "apply", "--cached", "--whitespace=nowarn", "-"); err != nil {
+			return rollback(fmt.Errorf("error staging commit %d: %v", i+1, err))
+		}
+		if _, err := runGit(messages[i]+"\n", "commit", "-q", "-F", "-"); err != nil {
+			return rollback(fmt.Errorf("error creating commit %d: %v", i+1, err))
+		}
+		sha, _ := runGit("", "rev-parse", "--short", "HEAD")
+		fmt.Printf("Created %s %s\n", strings.TrimSpace(sha), group.Message.Header())
+	}
+	return nil
+}
+
+// runSplit proposes to split the staged changes into several commits and
+// creates them once approved.
+func runSplit(args []string) error {
+	fs := flag.NewFlagSet("split", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "create the proposed commits without asking")
+	noCache := fs.Bool("no-cache", false, "always call the provider instead of reusing a cached response")
+	fs.Parse(args)
+
+	raw, err := runGit("", "diff", "--cached", "--binary", "--no-color", "--no-ext-diff")
+	if err != nil {
+		return err
+	}
+	files := parseDiff(raw)
+	if len(files) == 0 {
+		return fmt.Errorf("nothing is staged, stage the changes to split with git add")
+	}
+	hunks := splitHunks(files)
+
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Enter the Jira ticket name: ")
+	ticket, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("error reading ticket: %v", err)
+	}
+	ticket = strings.TrimSpace(ticket)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	provider, err := getProvider()
+	if err != nil {
+		return err
+	}
+
+	var groups []splitGroup
+	if actual, _, _ := resolveProvider(cfg, provider); actual == ProviderHeuristic || len(hunks) == 1 {
+		groups = clusterHunks(hunks, ticket)
+	} else {
+		req, err := splitRequest(cfg, hunks, files, ticket, *noCache)
+		if err != nil {
+			return err
+		}
+		result, err := generateCommitMessage(req, provider)
+		if err != nil {
+			return err
+		}
+		printUsageSummary(result)
+		if groups, err = parseSplitResponse(result.Text, hunks, ticket); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v, grouping the hunks by directory instead\n", err)
+			groups = clusterHunks(hunks, ticket)
+		}
+	}
+
+	messages := make([]string, len(groups))
+	for i, group := range groups {
+		component, _ := resolveScope(cfg.Scope, parseDiff(group.Patch(hunks)))
+		applyScope(group.Message, component, ticket)
+		if messages[i], err = renderCommitMessage(group.Message, cfg.Commit.Format); err != nil {
+			return err
+		}
+
+		fmt.Printf("\nCommit %d of %d:\n", i+1, len(groups))
+		for _, h := range group.Hunks {
+			fmt.Printf("  %s\n", h.Label())
+		}
+		fmt.Println()
+		for _, line := range strings.Split(messages[i], "\n") {
+			fmt.Println(strings.TrimRight("    "+line, " "))
+		}
+	}
+	fmt.Println()
+
+	if len(groups) == 1 {
+		fmt.Println("The staged changes already form a single commit.")
+	}
+	if !*yes {
+		fmt.Printf("Create %d commits? [y/N] ", len(groups))
+		answer, _ := reader.ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Println("Nothing was committed.")
+			return nil
+		}
+	}
+	return commitGroups(groups, hunks, messages)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// branchCommits returns the commits between base and HEAD, oldest first, along
+// with the merge base they start from.
+func branchCommits(base string) (string, []commitInfo, error) {
+	mergeBase, err := runGit("", "merge-base", base, "HEAD")
+	if err != nil {
+		return "", nil, err
+	}
+	mergeBase = strings.TrimSpace(mergeBase)
+
+	output, err := runGit("", "rev-list", "--reverse", "--no-merges", mergeBase+"..HEAD")
+	if err != nil {
+		return "", nil, err
+	}
+	var commits []commitInfo
+	for _, sha := range strings.Fields(output) {
+		c, err := readCommit(sha)
+		if err != nil {
+			return "", nil, err
+		}
+		commits = append(commits, c)
+	}
+	if len(commits) == 0 {
+		return "", nil, fmt.Errorf("no commits between %s and HEAD", base)
+	}
+	return mergeBase, commits, nil
+}
+
+// branchTickets returns the distinct tickets mentioned by commits, in order.
+func branchTickets(commits []commitInfo, keys []string) []string {
+	var tickets []string
+	seen := map[string]bool{}
+	for _, c := range commits {
+		for _, ticket := range findTickets(c.Message, keys) {
+			if !seen[ticket] {
+				seen[ticket] = true
+				tickets = append(tickets, ticket)
+			}
+		}
+	}
+	return tickets
+}
+
+// coAuthors returns a Co-authored-by trailer for every author of commits
+// other than the current user, who authors the squashed commit.
+func coAuthors(commits []commitInfo) []string {
+	self, _ := runGit("", "config", "user.email")
+	seen := map[string]bool{strings.ToLower(strings.TrimSpace(self)): true}
+	var trailers []string
+	for _, c := range commits {
+		if email := strings.ToLower(c.AuthorEmail); !seen[email] {
+			seen[email] = true
+			trailers = append(trailers, fmt.Sprintf("Co-authored-by: %s <%s>", c.AuthorName, c.AuthorEmail))
+		}
+	}
+	return trailers
+}
+
+// runSquash generates a single message for the commits between a base and
+// HEAD, and optionally squashes them into one commit.
+func runSquash(args []string) error {
+	fs := flag.NewFlagSet("squash", flag.ExitOnError)
+	ticketFlag := fs.String("ticket", "", "Jira ticket of the squashed commit, instead of the first one found in the messages")
+	commit := fs.Bool("commit", false, "squash the commits into one with the generated message")
+	noCache := fs.Bool("no-cache", false, "always call the provider instead of reusing a cached response")
+	fs.Usage = func() {
+		fmt.Println("Usage: commitly squash [flags] <base>")
+		fmt.Println("Example: commitly squash main")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	mergeBase, commits, err := branchCommits(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	provider, err := getProvider()
+	if err != nil {
+		return err
+	}
+
+	raw, err := runGit("", "diff", "--no-color", "--no-ext-diff", mergeBase, "HEAD")
+	if err != nil {
+		return err
+	}
+	diff, err := prepareDiff(cfg, raw, diffSource{Before: mergeBase, After: "HEAD"})
+	if err != nil {
+		return err
+	}
+
+	// The subjects of the squashed commits are sent along with the diff and
+	// are redacted the same way
+	var subjects strings.Builder
+	for _, c := range commits {
+		subject, hits, err := redactText(c.Subject(), fmt.Sprintf("commit %.7s", c.SHA), cfg.Redaction)
+		if err != nil {
+			return err
+		}
+		diff.Redactions = append(diff.Redactions, hits...)
+		subjects.WriteString("- " + subject + "\n")
+	}
+	if err := checkPreparedDiff(cfg, diff, true); err != nil {
+		return err
+	}
+
+	tickets := branchTickets(commits, cfg.Commit.TicketKeys)
+	ticket := *ticketFlag
+	if ticket == "" && len(tickets) > 0 {
+		ticket = tickets[0]
+	}
+	history, err := promptHistory(cfg, diff.Files, mergeBase)
+	if err != nil {
+		return err
+	}
+
+	component := resolveComponent(cfg, diff)
+	req := commitRequest(diff, ticket, component, history)
+	req.NoCache = *noCache
+	req.Prompt += "\n\nThese commits are squashed into the one being generated, so describe the combined change " +
+		"and list its notable changes in the body rather than every intermediate step. Their messages are:\n" + subjects.String()
+
+	result, err := generateCommitMessage(req, provider)
+	if err != nil {
+		return err
+	}
+	msg, _, err := finishCommitMessage(cfg, result, diff, ticket, component)
+	if err != nil {
+		return err
+	}
+	for _, other := range tickets {
+		if other != ticket {
+			msg.Footers = append(msg.Footers, "Refs: "+other)
+		}
+	}
+	msg.Footers = append(msg.Footers, coAuthors(commits)...)
+	message, err := renderCommitMessage(msg, cfg.Commit.Format)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nSquash message for %d commits since %.7s:\n", len(commits), mergeBase)
+	fmt.Println(message)
+	fmt.Println()
+	printUsageSummary(result)
+	if !*commit {
+		return nil
+	}
+
+	// Only the branch commits may end up in the squashed commit
+	if _, err := runGit("", "diff", "--cached", "--quiet"); err != nil {
+		return fmt.Errorf("there are staged changes, commit or unstage them before squashing")
+	}
+	head, err := runGit("", "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	head = strings.TrimSpace(head)
+	if _, err := runGit("", "reset", "-q", "--soft", mergeBase); err != nil {
+		return err
+	}
+	if _, err := runGit(message+"\n", "commit", "-q", "-F", "-"); err != nil {
+		if _, resetErr := runGit("", "reset", "-q", "--soft", head); resetErr != nil {
+			return fmt.Errorf("%v\nrestoring the branch also failed, reset it with: git reset --soft %s", err, head)
+		}
+		return fmt.Errorf("%v\nrestored the branch to %.7s", err, head)
+	}
+	sha, _ := runGit("", "rev-parse", "--short", "HEAD")
+	fmt.Printf("Squashed %d commits into %s. The previous HEAD is %.7s in the reflog.\n", len(commits), strings.TrimSpace(sha), head)
+	return nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// diffSource identifies the two sides of a diff so whole files can be read
+// from either of them
+type diffSource struct {
+	// Before and After are git revisions. An empty Before is the index and
+	// an empty After is the working tree.
+	Before string
+	After  string
+}
+
+// gitShow returns the content of name at rev, or at the index when rev is
+// empty. Missing files are returned empty.
+func gitShow(rev, name string) []byte {
+	output, err := exec.Command("git", "show", rev+":"+name).Output()
+	if err != nil {
+		return nil
+	}
+	return output
+}
+
+// before returns the content of name on the old side of the diff.
+func (s diffSource) before(name string) []byte {
+	return gitShow(s.Before, name)
+}
+
+// after returns the content of name on the new side of the diff.
+func (s diffSource) after(name string) []byte {
+	if s.After != "" {
+		return gitShow(s.After, name)
+	}
+	data, err := os.ReadFile(filepath.Join(getRepoRoot(), filepath.FromSlash(name)))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// symbolChange is a function, method or type that was added, removed or
+// modified
+type symbolChange struct {
+	File string
+	// Kind is func, method, type, class and so on
+	Kind string
+	Name string
+	// Change is added, removed or modified
+	Change string
+	// Before and After are the signatures on each side, empty when the
+	// symbol does not exist on that side
+	Before   string
+	After    string
+	Exported bool
+	// Package is the name of the Go package of the file, "main" for a
+	// command, which has no importable API
+	Package string
+}
+
+// SignatureChanged reports whether a modified symbol has a new signature, as
+// opposed to only a new body.
+func (c symbolChange) SignatureChanged() bool {
+	return c.Change == "modified" && c.Before != c.After
+}
+
+// declaration is a top-level symbol of a file
+type declaration struct {
+	Kind      string
+	Name      string
+	Signature string
+	// Text is the whole declaration, to tell whether its body changed
+	Text     string
+	Exported bool
+}
+
+// goDeclarations parses Go source and returns its functions, methods and
+// types keyed by name. Methods are named like "(*Server).Start".
+func goDeclarations(src []byte) (map[string]declaration, error) {
+	decls := map[string]declaration{}
+	if len(src) == 0 {
+		return decls, nil
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+
+	render := func(node any) string {
+		var buf bytes.Buffer
+		if err := format.Node(&buf, fset, node); err != nil {
+			return ""
+		}
+		return buf.String()
+	}
+	oneLine := func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	}
+
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			sig := *d
+			sig.Body, sig.Doc = nil, nil
+			sym := declaration{
+				Kind:      "func",
+				Name:      d.Name.Name,
+				Signature: oneLine(render(&sig)),
+				Text:      render(d),
+				Exported:  d.Name.IsExported(),
+			}
+			if d.Recv != nil && len(d.Recv.List) > 0 {
+				recv := render(d.Recv.List[0].Type)
+				sym.Kind = "method"
+				sym.Name = recv + "." + d.Name.Name
+				if strings.HasPrefix(recv, "*") {
+					sym.Name = "(" + recv + ")." + d.Name.Name
+				}
+				base := strings.TrimPrefix(recv, "*")
+				if i := strings.Index(base, "["); i >= 0 {
+					base = base[:i]
+				}
+				sym.Exported = sym.Exported && ast.IsExported(base)
+			}
+			decls[sym.Name] = sym
+		case *ast.GenDecl:
+			if d.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range d.Specs {
+				ts := spec.(*ast.TypeSpec)
+				kind := "type"
+				switch ts.Type.(type) {
+				case *ast.StructType:
+					kind = "struct"
+				case *ast.InterfaceType:
+					kind = "interface"
+				}
+				decls[ts.Name.Name] = declaration{
+					Kind:      kind,
+					Name:      ts.Name.Name,
+					Signature: "type " + ts.Name.Name + " " + kind,
+					Text:      render(ts),
+					Exported:  ts.Name.IsExported(),
+				}
+			}
+		}
+	}
+	return decls, nil
+}
+
+// declarationPattern finds a declaration of Kind on a single line. The first
+// submatch is the declared name.
+type declarationPattern struct {
+	Kind    string
+	Pattern *regexp.Regexp
+}
+
+// declarationPatterns are the lightweight extractors used for languages that
+// are not parsed, and for Go files that do not parse, by file extension.
+var declarationPatterns = map[string][]declarationPattern{
+	".go": {
+		{"func", regexp.MustCompile(`^func\s+(?:\([^)]*\)\s*)?([A-Za-z_]\w*)\s*[\[(]`)},
+		{"type", regexp.MustCompile(`^type\s+([A-Za-z_]\w*)\s`)},
+	},
+	".py": {
+		{"function", regexp.MustCompile(`^\s*(?:async\s+)?def\s+(\w+)\s*\(`)},
+		{"class", regexp.MustCompile(`^\s*class\s+(\w+)`)},
+	},
+	".js":  jsDeclarationPatterns,
+	".jsx": jsDeclarationPatterns,
+	".ts":  jsDeclarationPatterns,
+	".tsx": jsDeclarationPatterns,
+	".rs": {
+		{"fn", regexp.MustCompile(`^\s*(?:pub(?:\([^)]*\))?\s+)?(?:async\s+)?(?:unsafe\s+)?fn\s+(\w+)`)},
+		{"type", regexp.MustCompile(`^\s*(?:pub(?:\([^)]*\))?\s+)?(?:struct|enum|trait)\s+(\w+)`)},
+	},
+	".java": {
+		{"class", regexp.MustCompile(`^\s*(?:(?:public|protected|private|static|final|abstract|sealed)\s+)*(?:class|interface|enum|record)\s+(\w+)`)},
+		{"method", regexp.MustCompile(`^\s*(?:(?:public|protected|private|static|final|abstract|synchronized|default)\s+)+[\w<>\[\],.? ]+\s+(\w+)\s*\(`)},
+	},
+	".rb": {
+		{"method", regexp.MustCompile(`^\s*def\s+(?:self\.)?(\w+[?!=]?)`)},
+		{"class", regexp.MustCompile(`^\s*(?:class|module)\s+([A-Z]\w*)`)},
+	},
+	".php": {
+		{"function", regexp.MustCompile(`^\s*(?:(?:public|protected|private|static|final|abstract)\s+)*function\s+(\w+)\s*\(`)},
+		{"class", regexp.MustCompile(`^\s*(?:(?:abstract|final)\s+)?(?:class|interface|trait|enum)\s+(\w+)`)},
+	},
+}
+
+var jsDeclarationPatterns = []declarationPattern{
+	{"function", regexp.MustCompile(`^\s*(?:export\s+)?(?:default\s+)?(?:async\s+)?function\s*\*?\s*(\w+)`)},
+	{"class", regexp.MustCompile(`^\s*(?:export\s+)?(?:default\s+)?(?:abstract\s+)?class\s+(\w+)`)},
+	{"type", regexp.MustCompile(`^\s*(?:export\s+)?(?:interface|type|enum)\s+(\w+)`)},
+	{"function", regexp.MustCompile(`^\s*(?:export\s+)?const\s+(\w+)\s*=\s*(?:async\s*)?(?:\([^)]*\)|\w+)\s*=>`)},
+}
+
+// matchDeclaration returns the kind and name declared by line, if any.
+func matchDeclaration(patterns []declarationPattern, line string) (string, string, bool) {
+	for _, p := range patterns {
+		if m := p.Pattern.FindStringSubmatch(line); m != nil {
+			return p.Kind, m[1], true
+		}
+	}
+	return "", "", false
+}
+
+// isExportedDeclaration guesses whether a declaration is part of the public
+// API from the conventions of the language of ext.
+func isExportedDeclaration(ext, line, name string) bool {
+	switch ext {
+	case ".go":
+		return ast.IsExported(name)
+	case ".py", ".rb":
+		return !strings.HasPrefix(name, "_")
+	case ".js", ".jsx", ".ts", ".tsx":
+		return strings.Contains(line, "export ")
+	case ".rs":
+		return strings.HasPrefix(strings.TrimSpace(line), "pub")
+	case ".java", ".php":
+		return !strings.Contains(line, "private ") && !strings.Contains(line, "protected ")
+	}
+	return false
+}
+
+// regexDeclarations finds the declarations of a file with the lightweight
+// extractor for ext. Only signatures are compared, so body changes are not
+// detected.
+func regexDeclarations(ext string, src []byte) map[string]declaration {
+	decls := map[string]declaration{}
+	patterns := declarationPatterns[ext]
+	for _, line := range strings.Split(string(src), "\n") {
+		kind, name, ok := matchDeclaration(patterns, line)
+		if !ok || decls[name].Name != "" {
+			continue
+		}
+		sig := strings.TrimRight(strings.TrimSpace(line), " {:")
+		decls[name] = declaration{
+			Kind:      kind,
+			Name:      name,
+			Signature: sig,
+			Text:      sig,
+			Exported:  isExportedDeclaration(ext, line, name),
+		}
+	}
+	return decls
+}
+
+// fileDeclarations returns the declarations of src, parsing Go and falling
+// back to the regular expressions for other languages or invalid Go.
+func fileDeclarations(name string, src []byte) map[string]declaration {
+	ext := path.Ext(name)
+	if ext == ".go" {
+		if decls, err := goDeclarations(src); err == nil {
+			return decls
+		}
+	}
+	return regexDeclarations(ext, src)
+}
+
+// goPackageName returns the package clause of the Go file name, or "".
+func goPackageName(name string, src []byte) string {
+	if path.Ext(name) != ".go" || len(src) == 0 {
+		return ""
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, parser.PackageClauseOnly)
+	if err != nil {
+		return ""
+	}
+	return file.Name.Name
+}
+
+// extractSymbols compares the declarations of every changed file before and
+// after the change.
+func extractSymbols(source diffSource, files []fileDiff) []symbolChange {
+	var changes []symbolChange
+	for _, f := range files {
+		if f.Binary || len(declarationPatterns[path.Ext(f.Path)]) == 0 {
+			continue
+		}
+
+		var before, after map[string]declaration
+		var pkg string
+		if f.Status != "added" {
+			src := source.before(f.OldPath)
+			before, pkg = fileDeclarations(f.OldPath, src), goPackageName(f.OldPath, src)
+		}
+		if f.Status != "deleted" {
+			src := source.after(f.Path)
+			after = fileDeclarations(f.Path, src)
+			if name := goPackageName(f.Path, src); name != "" {
+				pkg = name
+			}
+		}
+
+		for _, name := range sortedKeys(after) {
+			a := after[name]
+			b, existed := before[name]
+			switch {
+			case !existed:
+				changes = append(changes, symbolChange{File: f.Path, Kind: a.Kind, Name: name, Change: "added",
+					After: a.Signature, Exported: a.Exported, Package: pkg})
+			case a.Text != b.Text:
+				changes = append(changes, symbolChange{File: f.Path, Kind: a.Kind, Name: name, Change: "modified",
+					Before: b.Signature, After: a.Signature, Exported: a.Exported || b.Exported, Package: pkg})
+			}
+		}
+		for _, name := range sortedKeys(before) {
+			if _, ok := after[name]; !ok {
+				b := before[name]
+				changes = append(changes, symbolChange{File: f.Path, Kind: b.Kind, Name: name, Change: "removed",
+					Before: b.Signature, Exported: b.Exported, Package: pkg})
+			}
+		}
+	}
+	return changes
+}
+
+// summarizeSymbols describes changes for the prompt, grouped by file.
+func summarizeSymbols(changes []symbolChange) string {
+	var b strings.Builder
+	file := ""
+	for _, c := range changes {
+		if c.File != file {
+			file = c.File
+			fmt.Fprintf(&b, "%s:\n", file)
+		}
+		switch {
+		case c.Change == "added":
+			fmt.Fprintf(&b, "  added %s %s: %s\n", c.Kind, c.Name, c.After)
+		case c.Change == "removed":
+			fmt.Fprintf(&b, "  removed %s %s: %s\n", c.Kind, c.Name, c.Before)
+		case c.SignatureChanged():
+			fmt.Fprintf(&b, "  changed signature of %s %s: %s -> %s\n", c.Kind, c.Name, c.Before, c.After)
+		default:
+			fmt.Fprintf(&b, "  modified %s %s\n", c.Kind, c.Name)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenUsage is the token usage a provider reported for one request
+type tokenUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// generationResult is a provider reply together with what it cost
+type generationResult struct {
+	Text     string
+	Provider Provider
+	Model    string
+	Usage    tokenUsage
+	// Cost is the estimated cost in USD, negative when the model has no known price
+	Cost float64
+	// Cached is set when the response was served from the cache at no cost
+	Cached bool
+}
+
+// ModelPrice is the price of a model in USD per million tokens
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// defaultPrices are list prices in USD per million tokens. Entries match a
+// model name exactly or as a prefix, so dated snapshots share a price.
+var defaultPrices = map[string]ModelPrice{
+	"gpt-4o":            {Input: 2.50, Output: 10.00},
+	"gpt-4o-mini":       {Input: 0.15, Output: 0.60},
+	"gpt-4.1":           {Input: 2.00, Output: 8.00},
+	"gpt-4.1-mini":      {Input: 0.40, Output: 1.60},
+	"gpt-4.1-nano":      {Input: 0.10, Output: 0.40},
+	"gpt-4-turbo":       {Input: 10.00, Output: 30.00},
+	"gpt-3.5-turbo":     {Input: 0.50, Output: 1.50},
+	"o1":                {Input: 15.00, Output: 60.00},
+	"o1-mini":           {Input: 1.10, Output: 4.40},
+	"o3":                {Input: 2.00, Output: 8.00},
+	"o3-mini":           {Input: 1.10, Output: 4.40},
+	"o4-mini":           {Input: 1.10, Output: 4.40},
+	"claude-3-5-sonnet": {Input: 3.00, Output: 15.00},
+	"claude-3-7-sonnet": {Input: 3.00, Output: 15.00},
+	"claude-sonnet-4":   {Input: 3.00, Output: 15.00},
+	"claude-3-5-haiku":  {Input: 0.80, Output: 4.00},
+	"claude-3-haiku":    {Input: 0.25, Output: 1.25},
+	"claude-3-opus":     {Input: 15.00, Output: 75.00},
+	"claude-opus-4":     {Input: 15.00, Output: 75.00},
+	"deepseek-chat":     {Input: 0.27, Output: 1.10},
+	"deepseek-reasoner": {Input: 0.55, Output: 2.19},
+	"gemini-1.5-flash":  {Input: 0.075, Output: 0.30},
+	"gemini-1.5-pro":    {Input: 1.25, Output: 5.00},
+	"gemini-2.0-flash":  {Input: 0.10, Output: 0.40},
+	"gemini-2.5-flash":  {Input: 0.30, Output: 2.50},
+	"gemini-2.5-pro":    {Input: 1.25, Output: 10.00},
+	// The heuristic provider runs locally
+	"heuristic": {},
+}
+
+// lookupPrice finds the price of model, preferring the configured price table
+// and falling back to the longest matching built-in prefix.
+func lookupPrice(cfg *Config, model string) (ModelPrice, bool) {
+	if cfg != nil {
+		if price, ok := cfg.Prices[model]; ok {
+			return price, true
+		}
+	}
+	best := ""
+	for name := range defaultPrices {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+	return defaultPrices[best], true
+}
+
+// estimateCost returns the cost in USD of usage on model, or -1 when the
+// price is unknown.
+func estimateCost(cfg *Config, model string, usage tokenUsage) float64 {
+	price, ok := lookupPrice(cfg, model)
+	if !ok {
+		return -1
+	}
+	return (float64(usage.InputTokens)*price.Input + float64(usage.OutputTokens)*price.Output) / 1e6
+}
+
+// parsePrice parses "<input>,<output>" prices per million tokens.
+func parsePrice(value string) (ModelPrice, error) {
+	in, out, ok := strings.Cut(value, ",")
+	if !ok {
+		return ModelPrice{}, fmt.Errorf("invalid price %q, expected <input>,<output> in USD per million tokens", value)
+	}
+	input, err := strconv.ParseFloat(strings.TrimSpace(in), 64)
+	if err != nil || input < 0 {
+		return ModelPrice{}, fmt.Errorf("invalid input price %q", in)
+	}
+	output, err := strconv.ParseFloat(strings.TrimSpace(out), 64)
+	if err != nil || output < 0 {
+		return ModelPrice{}, fmt.Errorf("invalid output price %q", out)
+	}
+	return ModelPrice{Input: input, Output: output}, nil
+}
+
+func formatCost(cost float64) string {
+	if cost < 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf("$%.4f", cost)
+}
+
+// printUsageSummary prints the one line usage summary shown after a run.
+func printUsageSummary(result *generationResult) {
+	if result.Cached {
+		fmt.Printf("Usage: served from cache, no cost (%s/%s, run with --no-cache to regenerate)\n", result.Provider, result.Model)
+		return
+	}
+	line := fmt.Sprintf("Usage: %d input + %d output tokens, estimated cost %s (%s/%s)",
+		result.Usage.InputTokens, result.Usage.OutputTokens, formatCost(result.Cost), result.Provider, result.Model)
+	if result.Cost < 0 {
+		line += fmt.Sprintf("\nSet a price with: commitly config set prices.%s <input>,<output>", result.Model)
+	}
+	fmt.Println(line)
+}
+
+// usageRecord is one line of the usage log
+type usageRecord struct {
+	Time         time.Time `json:"time"`
+	Repo         string    `json:"repo"`
+	Provider     string    `json:"provider"`
+	Model        string    `json:"model"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	Cost         float64   `json:"cost"`
+}
+
+func getUsagePath() string {
+	return filepath.Join(getDataDir(), "usage.jsonl")
+}
+
+// recordUsage appends the usage of result to the local usage log.
+func recordUsage(result *generationResult) error {
+	record := usageRecord{
+		Time:         time.Now(),
+		Repo:         getRepoRoot(),
+		Provider:     string(result.Provider),
+		Model:        result.Model,
+		InputTokens:  result.Usage.InputTokens,
+		OutputTokens: result.Usage.OutputTokens,
+		Cost:         result.Cost,
+	}
+	return appendJSONLine(getUsagePath(), record)
+}
+
+// appendJSONLine appends v as a single JSON line to path, creating the file
+// and its directory when needed.
+func appendJSONLine(path string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("error creating %s: %v", filepath.Dir(path), err)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error serializing record: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	return nil
+}
+
+// loadUsage reads the usage log, skipping records older than since.
+func loadUsage(since time.Time) ([]usageRecord, error) {
+	f, err := os.Open(getUsagePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening usage log: %v", err)
+	}
+	defer f.Close()
+
+	var records []usageRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record usageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if !record.Time.Before(since) {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading usage log: %v", err)
+	}
+	return records, nil
+}
+
+// getRepoRoot returns the top level directory of the current git repository,
+// or an empty string outside of one.
+func getRepoRoot() string {
+	output, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// parseSince parses a "--since" value: a date (2006-01-02) or a number of
+// days such as "30d".
+func parseSince(value string) (time.Time, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err == nil && days >= 0 {
+			now := time.Now()
+			today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+			return today.AddDate(0, 0, -days), nil
+		}
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --since value %q, expected YYYY-MM-DD or a number of days like 30d", value)
+	}
+	return t, nil
+}
+
+type usageTotals struct {
+	Requests     int
+	InputTokens  int
+	OutputTokens int
+	Cost         float64
+	UnknownCost  bool
+}
+
+func (t *usageTotals) add(record usageRecord) {
+	t.Requests++
+	t.InputTokens += record.InputTokens
+	t.OutputTokens += record.OutputTokens
+	if record.Cost < 0 {
+		t.UnknownCost = true
+	} else {
+		t.Cost += record.Cost
+	}
+}
+
+func (t *usageTotals) String() string {
+	cost := fmt.Sprintf("$%.4f", t.Cost)
+	if t.UnknownCost {
+		cost += "+"
+	}
+	return fmt.Sprintf("%5d requests  %9d in  %8d out  %10s", t.Requests, t.InputTokens, t.OutputTokens, cost)
+}
+
+// runUsage implements "commitly usage".
+func runUsage(args []string) error {
+	usageCmd := flag.NewFlagSet("usage", flag.ExitOnError)
+	since := usageCmd.String("since", "30d", "only include usage since a date (YYYY-MM-DD) or a number of days (30d)")
+	by := usageCmd.String("by", "", "group by a single dimension: day, repo or provider")
+	usageCmd.Parse(args)
+
+	start, err := parseSince(*since)
+	if err != nil {
+		return err
+	}
+	records, err := loadUsage(start)
+	if err != nil {
+		return err
+	}
+
+	groupings := []string{"day", "repo", "provider"}
+	if *by != "" {
+		if *by != "day" && *by != "repo" && *by != "provider" {
+			return fmt.Errorf("invalid --by value %q, expected day, repo or provider", *by)
+		}
+		groupings = []string{*by}
+	}
+
+	fmt.Printf("Usage since %s\n", start.Format("2006-01-02"))
+	for _, grouping := range groupings {
+		groups := map[string]*usageTotals{}
+		for _, record := range records {
+			var key string
+			switch grouping {
+			case "day":
+				key = record.Time.Local().Format("2006-01-02")
+			case "repo":
+				key = record.Repo
+				if key == "" {
+					key = "[no repository]"
+				}
+			case "provider":
+				key = record.Provider + "/" + record.Model
+			}
+			if groups[key] == nil {
+				groups[key] = &usageTotals{}
+			}
+			groups[key].add(record)
+		}
+
+		keys := sortedKeys(groups)
+		if grouping != "day" {
+			sort.SliceStable(keys, func(i, j int) bool { return groups[keys[i]].Cost > groups[keys[j]].Cost })
+		}
+
+		fmt.Printf("\nBy %s:\n", grouping)
+		for _, key := range keys {
+			fmt.Printf("  %-40s %s\n", key, groups[key])
+		}
+	}
+
+	var total usageTotals
+	for _, record := range records {
+		total.add(record)
+	}
+	fmt.Printf("\n  %-40s %s\n", "Total", &total)
+	if total.UnknownCost {
+		fmt.Println("\n+ some requests used models without a known price")
+	}
+	return nil
+}